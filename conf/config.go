@@ -2,6 +2,7 @@ package conf
 
 import (
 	"context"
+	"fmt"
 	"github.com/alecthomas/units"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/config"
@@ -20,10 +21,21 @@ type Config struct {
 	Modules map[string]Module `mapstructure:"modules"`
 }
 
+// ModuleNames 按名称排序返回所有模块名
+func (c *Config) ModuleNames() []string {
+	return sortedKeys(c.Modules)
+}
+
 type Module struct {
-	Prober  string        `mapstructure:"prober" validate:"required"`
-	Timeout time.Duration `mapstructure:"timeout"`
-	HTTP    *HTTPProbe    `mapstructure:"http"`
+	Prober    string          `mapstructure:"prober" validate:"required"`
+	Timeout   time.Duration   `mapstructure:"timeout"`
+	HTTP      *HTTPProbe      `mapstructure:"http"`
+	TCP       *TCPProbe       `mapstructure:"tcp"`
+	DNS       *DNSProbe       `mapstructure:"dns"`
+	ICMP      *ICMPProbe      `mapstructure:"icmp"`
+	GRPC      *GRPCProbe      `mapstructure:"grpc"`
+	WebSocket *WebSocketProbe `mapstructure:"websocket"`
+	TLS       *TLSProbe       `mapstructure:"tls"`
 }
 
 func NewDefaultModule() *Module {
@@ -33,38 +45,80 @@ func NewDefaultModule() *Module {
 }
 
 type HTTPProbe struct {
-	ValidStatusCode              []int                   `mapstructure:"valid_status_code"`             // Verify response code
-	ValidHTTPVersions            []string                `mapstructure:"valid_status_code"`             // Adapt to HTTP1.x/HTTP2
-	IPProtocol                   IPProtocol              `mapstructure:"preferred_ip_protocol"`         // Adapt to IPV4/IPV6
-	IPProtocolFallback           bool                    `mapstructure:"ip_protocol_fallback"`          // 允许IPV6协议降级
-	SkipResolvePhaseWithProxy    bool                    `mapstructure:"skip_resolve_phase_with_proxy"` // 解析域名时不使用代理
-	NoFollowRedirects            *bool                   `mapstructure:"no_follow_redirects"`           // 禁止重定向
-	FailIfSSL                    bool                    `mapstructure:"fail_if_ssl"`                   // 如果被监控项为HTTPS，则失败
-	FailIfNotSSL                 bool                    `mapstructure:"fail_if_not_ssl"`               // 如果被监控项不是HTTPS，则失败
-	Method                       string                  `mapstructure:"method"`
-	Headers                      map[string]string       `mapstructure:"headers"`                     // Request Headers
-	FailIfBodyMatchesRegexp      []Regexp                `mapstructure:"fail_if_body_matches_regexp"` // if Response Headers not include origin strings, return failed  Regexp是对regex.Regexp的封装，包含了源正则字符串
-	FailIfBodyNotMatchesRegexp   []Regexp                `mapstructure:"fail_if_body_not_matches_regexp"`
-	FailIfHeaderMatchesRegexp    []HeaderMatch           `mapstructure:"fail_if_header_matches"`
-	FailIfHeaderNotMatchesRegexp []HeaderMatch           `mapstructure:"fail_if_header_not_matches"`
-	Body                         string                  `mapstructure:"body,omitempty"`
-	Compression                  string                  `mapstructure:"compression"`        // 指定压缩算法 e.g. gzip
-	BodySizeLimit                units.Base2Bytes        `mapstructure:"body_size_limit"`    // units是一个单位转换工作 e.g. 1Mi => 1024*1024
-	HTTPClientConfig             config.HTTPClientConfig `mapstructure:"http_client_config"` // prometheus 官方的工具包，包括了BearToken、BasicAuth、TLS、SSL等协议的认证，主要作用是配置http request
+	ValidStatusCode              StatusCodeMatchers `mapstructure:"valid_status_code"`             // Verify response code e.g. [200, "3xx", "400-403", "!401"]
+	ValidRedirectStatusCode      StatusCodeMatchers `mapstructure:"valid_redirect_status_code"`    // 校验每一跳重定向的响应码，为空时不校验
+	ValidHTTPVersions            []string           `mapstructure:"valid_http_versions"`           // Adapt to HTTP1.x/HTTP2
+	IPProtocol                   IPProtocol         `mapstructure:"preferred_ip_protocol"`         // Adapt to IPV4/IPV6
+	IPProtocolFallback           bool               `mapstructure:"ip_protocol_fallback"`          // 允许IPV6协议降级
+	SkipResolvePhaseWithProxy    bool               `mapstructure:"skip_resolve_phase_with_proxy"` // 解析域名时不使用代理
+	NoFollowRedirects            *bool              `mapstructure:"no_follow_redirects"`           // 禁止重定向
+	MaxRedirects                 int                `mapstructure:"max_redirects"`                 // 最多跟随的重定向次数
+	ForceHTTP2                   bool               `mapstructure:"force_http2"`                   // 强制使用HTTP/2，明文http使用h2c prior knowledge
+	FailIfSSL                    bool               `mapstructure:"fail_if_ssl"`                   // 如果被监控项为HTTPS，则失败
+	FailIfNotSSL                 bool               `mapstructure:"fail_if_not_ssl"`               // 如果被监控项不是HTTPS，则失败
+	FailIfTLSVersionBelow        TLSVersion         `mapstructure:"fail_if_tls_version_below"`     // 协商的TLS版本低于该版本则失败 e.g. TLS12
+	AllowedCipherSuites          []TLSCipherSuite   `mapstructure:"allowed_cipher_suites"`         // 协商的加密套件不在列表中则失败
+	RequiredALPNProtocol         string             `mapstructure:"required_alpn_protocol"`        // 协商的ALPN协议不一致则失败 e.g. h2
+	Revocation                   RevocationConfig   `mapstructure:"revocation"`                    // 最后一跳的叶子证书被吊销则失败
+	CertificatePins              PinConfig          `mapstructure:"certificate_pins"`              // 最后一跳的证书链中没有证书匹配pin则失败
+	FanOut                       FanOutConfig       `mapstructure:"fan_out"`                       // 并发探测target解析出的每个地址
+	Method                       string             `mapstructure:"method"`
+	Headers                      Headers            `mapstructure:"headers"`                     // Request Headers
+	FailIfBodyMatchesRegexp      []Regexp           `mapstructure:"fail_if_body_matches_regexp"` // if Response Headers not include origin strings, return failed  Regexp是对regex.Regexp的封装，包含了源正则字符串
+	FailIfBodyNotMatchesRegexp   []Regexp           `mapstructure:"fail_if_body_not_matches_regexp"`
+	FailIfHeaderMatchesRegexp    []HeaderMatch      `mapstructure:"fail_if_header_matches"`
+	FailIfHeaderNotMatchesRegexp []HeaderMatch      `mapstructure:"fail_if_header_not_matches"`
+	// 最终请求的URL必须匹配的正则 e.g. ^https://
+	FailIfFinalURLNotMatchesRegexp []Regexp `mapstructure:"fail_if_final_url_not_matches_regexp"`
+	// 每次重定向的目标地址（Location解析后的绝对URL）必须匹配的正则
+	FailIfRedirectLocationNotMatchesRegexp []Regexp                `mapstructure:"fail_if_redirect_location_not_matches_regexp"`
+	Body                                   string                  `mapstructure:"body,omitempty"`
+	Compression                            string                  `mapstructure:"compression"`        // 指定压缩算法 e.g. gzip
+	BodySizeLimit                          units.Base2Bytes        `mapstructure:"body_size_limit"`    // units是一个单位转换工作 e.g. 1Mi => 1024*1024
+	HTTPClientConfig                       config.HTTPClientConfig `mapstructure:"http_client_config"` // prometheus 官方的工具包，包括了BearToken、BasicAuth、TLS、SSL等协议的认证，主要作用是配置http request
 }
 
 func NewDefaultHTTPProbe() *HTTPProbe {
 	return &HTTPProbe{
 		IPProtocol:         IPV4,
 		IPProtocolFallback: true,
+		MaxRedirects:       10,
 		Method:             http.MethodGet,
 		HTTPClientConfig:   config.DefaultHTTPClientConfig,
 	}
 }
 
+// FanOutConfig 对target解析出的每个地址并发探测，每个地址的metrics带有ip label
+type FanOutConfig struct {
+	Enabled       bool   `mapstructure:"enabled"`
+	SuccessPolicy string `mapstructure:"success_policy"` // all(默认)、any 或 quorum(超过半数的地址成功)
+}
+
+// fan_out.success_policy 的取值
+const (
+	FanOutPolicyAll    = "all"
+	FanOutPolicyAny    = "any"
+	FanOutPolicyQuorum = "quorum"
+)
+
+// Succeeded 按 success_policy 汇总每个地址的探测结果，total 为0时总是失败
+func (f FanOutConfig) Succeeded(succeeded, total int) bool {
+	if total == 0 {
+		return false
+	}
+	switch f.SuccessPolicy {
+	case FanOutPolicyAny:
+		return succeeded > 0
+	case FanOutPolicyQuorum:
+		return succeeded*2 > total
+	default:
+		return succeeded == total
+	}
+}
+
 type Regexp struct {
 	*regexp.Regexp
-	origin string `mapstructure:"origin"`
+	origin string
 }
 
 func NewRegexp(regexExpr string) (*Regexp, error) {
@@ -72,6 +126,43 @@ func NewRegexp(regexExpr string) (*Regexp, error) {
 	return &Regexp{regex, regexExpr}, err
 }
 
+// String 返回原始的正则表达式字符串
+func (re Regexp) String() string {
+	return re.origin
+}
+
+// UnmarshalText 实现 encoding.TextUnmarshaler，供 mapstructure 和 json 使用
+func (re *Regexp) UnmarshalText(text []byte) error {
+	r, err := NewRegexp(string(text))
+	if err != nil {
+		return fmt.Errorf("could not compile regular expression %q: %w", string(text), err)
+	}
+	*re = *r
+	return nil
+}
+
+// MarshalText 实现 encoding.TextMarshaler
+func (re Regexp) MarshalText() ([]byte, error) {
+	return []byte(re.origin), nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (re *Regexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return re.UnmarshalText([]byte(s))
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (re Regexp) MarshalYAML() (interface{}, error) {
+	if re.Regexp != nil {
+		return re.origin, nil
+	}
+	return nil, nil
+}
+
 // MustNewRegexp works like NewRegexp, but panics if the regular expression does not compile.
 func MustNewRegexp(regexExpr string) *Regexp {
 	re, err := NewRegexp(regexExpr)
@@ -81,17 +172,167 @@ func MustNewRegexp(regexExpr string) *Regexp {
 	return re
 }
 
+// TCPProbe tcp探测配置，target 格式为 host:port
+type TCPProbe struct {
+	IPProtocol         IPProtocol       `mapstructure:"preferred_ip_protocol"`
+	IPProtocolFallback bool             `mapstructure:"ip_protocol_fallback"`
+	SourceIPAddress    string           `mapstructure:"source_ip_address"` // 建立连接使用的本地地址
+	QueryResponse      []QueryResponse  `mapstructure:"query_response"`    // 按顺序执行的对话步骤
+	TLS                bool             `mapstructure:"tls"`               // 建立连接后立即进行TLS握手
+	TLSConfig          config.TLSConfig `mapstructure:"tls_config"`
+}
+
+func NewDefaultTCPProbe() *TCPProbe {
+	return &TCPProbe{
+		IPProtocol:         IPV4,
+		IPProtocolFallback: true,
+	}
+}
+
+// QueryResponse tcp对话中的一步，依次执行 expect、send、starttls
+type QueryResponse struct {
+	Expect   Regexp `mapstructure:"expect"`   // 逐行读取直到匹配，send 中可以用 ${1} 引用匹配的分组
+	Send     string `mapstructure:"send"`     // 发送的内容，自动追加换行
+	StartTLS bool   `mapstructure:"starttls"` // 将连接升级为TLS，e.g. SMTP STARTTLS
+}
+
+// DNSProbe dns探测配置，target 为dns服务器地址 host[:port]
+type DNSProbe struct {
+	IPProtocol         IPProtocol       `mapstructure:"preferred_ip_protocol"`
+	IPProtocolFallback bool             `mapstructure:"ip_protocol_fallback"`
+	SourceIPAddress    string           `mapstructure:"source_ip_address"`
+	TransportProtocol  string           `mapstructure:"transport_protocol"` // udp 或 tcp
+	DNSOverTLS         bool             `mapstructure:"dns_over_tls"`       // DoT，只能使用tcp，默认端口853
+	TLSConfig          config.TLSConfig `mapstructure:"tls_config"`
+	QueryName          string           `mapstructure:"query_name" validate:"required"`
+	QueryType          string           `mapstructure:"query_type"`  // e.g. A、AAAA、MX
+	QueryClass         string           `mapstructure:"query_class"` // e.g. IN、CH
+	Recursion          bool             `mapstructure:"recursion_desired"`
+	ValidRcodes        []string         `mapstructure:"valid_rcodes"` // 为空时只接受 NOERROR
+	ValidateAnswer     DNSRRValidator   `mapstructure:"validate_answer_rrs"`
+	ValidateAuthority  DNSRRValidator   `mapstructure:"validate_authority_rrs"`
+	ValidateAdditional DNSRRValidator   `mapstructure:"validate_additional_rrs"`
+}
+
+func NewDefaultDNSProbe() *DNSProbe {
+	return &DNSProbe{
+		IPProtocol:         IPV4,
+		IPProtocolFallback: true,
+		TransportProtocol:  "udp",
+		QueryType:          "A",
+		QueryClass:         "IN",
+		Recursion:          true,
+	}
+}
+
+// DNSRRValidator 校验响应中某一部分的资源记录，正则匹配的是记录的文本格式
+// e.g. "example.com.	300	IN	A	93.184.216.34"
+type DNSRRValidator struct {
+	FailIfMatchesRegexp     []Regexp `mapstructure:"fail_if_matches_regexp"`      // 任意一条记录匹配即失败
+	FailIfAllMatchRegexp    []Regexp `mapstructure:"fail_if_all_match_regexp"`    // 所有记录都匹配才失败
+	FailIfNotMatchesRegexp  []Regexp `mapstructure:"fail_if_not_matches_regexp"`  // 任意一条记录不匹配即失败
+	FailIfNoneMatchesRegexp []Regexp `mapstructure:"fail_if_none_matches_regexp"` // 没有任何记录匹配才失败
+}
+
+// ICMPProbe icmp探测配置，target 为host或ip
+type ICMPProbe struct {
+	IPProtocol         IPProtocol `mapstructure:"preferred_ip_protocol"`
+	IPProtocolFallback bool       `mapstructure:"ip_protocol_fallback"`
+	SourceIPAddress    string     `mapstructure:"source_ip_address"`
+	PayloadSize        int        `mapstructure:"payload_size"`  // echo request 中数据部分的字节数
+	DontFragment       bool       `mapstructure:"dont_fragment"` // 只支持ip4，需要使用raw socket
+	TTL                int        `mapstructure:"ttl"`           // ip4的TTL或ip6的hop limit，0 表示使用系统默认值
+}
+
+func NewDefaultICMPProbe() *ICMPProbe {
+	return &ICMPProbe{
+		IPProtocol:         IPV4,
+		IPProtocolFallback: true,
+	}
+}
+
+// GRPCProbe grpc健康检查配置，调用 grpc.health.v1.Health/Check，target 格式为 host:port
+type GRPCProbe struct {
+	IPProtocol         IPProtocol       `mapstructure:"preferred_ip_protocol"`
+	IPProtocolFallback bool             `mapstructure:"ip_protocol_fallback"`
+	Service            string           `mapstructure:"service"` // 检查的服务名，为空时检查整个服务器
+	TLS                bool             `mapstructure:"tls"`
+	TLSConfig          config.TLSConfig `mapstructure:"tls_config"`
+}
+
+func NewDefaultGRPCProbe() *GRPCProbe {
+	return &GRPCProbe{
+		IPProtocol:         IPV4,
+		IPProtocolFallback: true,
+	}
+}
+
+// WebSocketProbe websocket探测配置，target 为 ws:// 或 wss:// 地址
+type WebSocketProbe struct {
+	IPProtocol         IPProtocol               `mapstructure:"preferred_ip_protocol"`
+	IPProtocolFallback bool                     `mapstructure:"ip_protocol_fallback"`
+	Headers            Headers                  `mapstructure:"headers"`        // 握手请求中携带的header
+	QueryResponse      []WebSocketQueryResponse `mapstructure:"query_response"` // 握手成功后按顺序执行的对话步骤
+	HTTPClientConfig   config.HTTPClientConfig  `mapstructure:"http_client_config"`
+}
+
+func NewDefaultWebSocketProbe() *WebSocketProbe {
+	return &WebSocketProbe{
+		IPProtocol:         IPV4,
+		IPProtocolFallback: true,
+		HTTPClientConfig:   config.DefaultHTTPClientConfig,
+	}
+}
+
+// WebSocketQueryResponse websocket对话中的一步，先发送 send 再等待匹配 expect 的消息
+type WebSocketQueryResponse struct {
+	Send   string `mapstructure:"send"`   // 以文本消息发送
+	Expect Regexp `mapstructure:"expect"` // 依次读取消息直到匹配
+}
+
+// TLSProbe tls探测配置，只完成TLS握手并导出证书链信息，target 格式为 host[:port]，默认端口443
+type TLSProbe struct {
+	IPProtocol         IPProtocol       `mapstructure:"preferred_ip_protocol"`
+	IPProtocolFallback bool             `mapstructure:"ip_protocol_fallback"`
+	TLSConfig          config.TLSConfig `mapstructure:"tls_config"`       // server_name 为空时使用target的host作为SNI
+	Revocation         RevocationConfig `mapstructure:"revocation"`       // 叶子证书被吊销则失败
+	CertificatePins    PinConfig        `mapstructure:"certificate_pins"` // 证书链中没有证书匹配pin则失败
+}
+
+func NewDefaultTLSProbe() *TLSProbe {
+	return &TLSProbe{
+		IPProtocol:         IPV4,
+		IPProtocolFallback: true,
+	}
+}
+
+// Headers 请求中携带的header
+type Headers map[string]string
+
+// MarshalYAML 输出配置时隐藏认证信息
+func (h Headers) MarshalYAML() (interface{}, error) {
+	redacted := make(map[string]string, len(h))
+	for name, value := range h {
+		if utils.IsSensitiveHeader(name) {
+			value = utils.RedactedHeaderValue
+		}
+		redacted[name] = value
+	}
+	return redacted, nil
+}
+
 type HeaderMatch struct {
-	Header       string `mapstructure:"header"`
-	Regexp       Regexp `mapstructure:"regexp"`
+	Header       string `mapstructure:"header" validate:"required"`
+	Regexp       Regexp `mapstructure:"regexp" validate:"required"`
 	AllowMissing bool   `mapstructure:"allow_missing"` // 是否允许不含value
 }
 
-func (h HTTPProbe) LookUpWithoutProxy(ctx context.Context, target string, durationGaugeVec *prometheus.GaugeVec) (ip *net.IPAddr, err error) {
+// LookUpWithoutProxy 未配置代理（或配置了跳过代理解析）时解析target，解析耗时记录到resolve阶段
+func (h HTTPProbe) LookUpWithoutProxy(ctx context.Context, target string, durationGaugeVec *prometheus.GaugeVec, registry prometheus.Registerer) (ip *net.IPAddr, err error) {
 	var lookUpTime float64
 
 	if h.SkipResolvePhaseWithProxy || h.HTTPClientConfig.ProxyURL.URL == nil {
-		ip, lookUpTime, err = h.ChooseProtocol(ctx, target)
+		ip, lookUpTime, err = h.ChooseProtocol(ctx, target, registry)
 		durationGaugeVec.WithLabelValues("resolve").Add(lookUpTime)
 	}
 	return
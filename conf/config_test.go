@@ -1,8 +1,12 @@
 package conf_test
 
 import (
+	"crypto/tls"
 	"fmt"
+	"github.com/alecthomas/units"
 	"github.com/yuanyp8/http_exporter/conf"
+	"gopkg.in/yaml.v2"
+	"strings"
 	"testing"
 )
 
@@ -13,3 +17,222 @@ func TestLoadConfig(t *testing.T) {
 	}
 	fmt.Println(conf.C().C.Modules["http_get_2xx"].HTTP.Method)
 }
+
+func TestLoadConfigDecode(t *testing.T) {
+	sc := &conf.SafeConfig{C: &conf.Config{}}
+	if err := sc.ReloadConfig("testdata/config-demo.yaml"); err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+
+	get := sc.C.Modules["http_get_2xx"].HTTP
+	for code, valid := range map[int]bool{200: true, 301: true, 304: false, 404: false} {
+		if ok, _ := get.ValidStatusCode.Match(code); ok != valid {
+			t.Errorf("Expected valid_status_code to match %d: %t, got %t", code, valid, ok)
+		}
+	}
+	if ok, _ := get.ValidRedirectStatusCode.Match(307); ok {
+		t.Errorf("Expected valid_redirect_status_code to reject 307")
+	}
+	if get.MaxRedirects != 3 {
+		t.Errorf("Expected max_redirects to be 3, got %d", get.MaxRedirects)
+	}
+	if len(get.FailIfFinalURLNotMatchesRegexp) != 1 || get.FailIfFinalURLNotMatchesRegexp[0].MatchString("http://example.com") {
+		t.Errorf("fail_if_final_url_not_matches_regexp not decoded: %v", get.FailIfFinalURLNotMatchesRegexp)
+	}
+	if len(get.FailIfRedirectLocationNotMatchesRegexp) != 1 {
+		t.Errorf("fail_if_redirect_location_not_matches_regexp not decoded: %v", get.FailIfRedirectLocationNotMatchesRegexp)
+	}
+	if !get.FanOut.Enabled || get.FanOut.SuccessPolicy != conf.FanOutPolicyQuorum {
+		t.Errorf("fan_out not decoded: %+v", get.FanOut)
+	}
+
+	bodyRegex := sc.C.Modules["http_body_regex"].HTTP
+	if len(bodyRegex.FailIfBodyMatchesRegexp) != 1 || !bodyRegex.FailIfBodyMatchesRegexp[0].MatchString("request failed") {
+		t.Errorf("fail_if_body_matches_regexp not decoded: %v", bodyRegex.FailIfBodyMatchesRegexp)
+	}
+	if len(bodyRegex.FailIfBodyNotMatchesRegexp) != 1 || bodyRegex.FailIfBodyNotMatchesRegexp[0].String() != "success" {
+		t.Errorf("fail_if_body_not_matches_regexp not decoded: %v", bodyRegex.FailIfBodyNotMatchesRegexp)
+	}
+	if bodyRegex.BodySizeLimit != units.MiB {
+		t.Errorf("Expected body_size_limit to be 1MiB, got %v", bodyRegex.BodySizeLimit)
+	}
+
+	headerRegex := sc.C.Modules["http_header_regex"].HTTP
+	if len(headerRegex.FailIfHeaderNotMatchesRegexp) != 1 || !headerRegex.FailIfHeaderNotMatchesRegexp[0].Regexp.MatchString("example.com") {
+		t.Errorf("fail_if_header_not_matches not decoded: %v", headerRegex.FailIfHeaderNotMatchesRegexp)
+	}
+	if headerRegex.IPProtocol != conf.IPV6 {
+		t.Errorf("Expected preferred_ip_protocol to be ip6, got %q", headerRegex.IPProtocol)
+	}
+
+	// 未配置的字段使用默认值
+	if !headerRegex.IPProtocolFallback || !headerRegex.HTTPClientConfig.FollowRedirects || headerRegex.MaxRedirects != 10 {
+		t.Errorf("Expected default values to be kept, got %+v", headerRegex)
+	}
+
+	post := sc.C.Modules["http_post_2xx"].HTTP
+	if post.HTTPClientConfig.BasicAuth == nil || post.HTTPClientConfig.BasicAuth.Username != "test user" {
+		t.Errorf("http_client_config.basic_auth not decoded: %+v", post.HTTPClientConfig.BasicAuth)
+	}
+	ssl := sc.C.Modules["http_ssl_probe"].HTTP
+	if ssl.HTTPClientConfig.ProxyURL.URL == nil || ssl.HTTPClientConfig.ProxyURL.Host != "localhost:3128" {
+		t.Errorf("http_client_config.proxy_url not decoded: %+v", ssl.HTTPClientConfig.ProxyURL)
+	}
+	if ssl.FailIfTLSVersionBelow != conf.TLSVersion(tls.VersionTLS12) {
+		t.Errorf("Expected fail_if_tls_version_below to be TLS12, got %v", ssl.FailIfTLSVersionBelow)
+	}
+	if len(ssl.AllowedCipherSuites) != 2 || ssl.AllowedCipherSuites[1] != conf.TLSCipherSuite(tls.TLS_AES_128_GCM_SHA256) {
+		t.Errorf("allowed_cipher_suites not decoded: %v", ssl.AllowedCipherSuites)
+	}
+
+	smtp := sc.C.Modules["smtp_starttls"]
+	if smtp.TCP == nil || smtp.HTTP != nil {
+		t.Fatalf("Expected only the tcp probe to be configured, got %+v", smtp)
+	}
+	if len(smtp.TCP.QueryResponse) != 5 || !smtp.TCP.QueryResponse[3].StartTLS || smtp.TCP.QueryResponse[4].Send != "QUIT" {
+		t.Errorf("query_response not decoded: %+v", smtp.TCP.QueryResponse)
+	}
+	if !smtp.TCP.TLSConfig.InsecureSkipVerify || smtp.TCP.IPProtocol != conf.IPV4 || !smtp.TCP.IPProtocolFallback {
+		t.Errorf("tcp not decoded: %+v", smtp.TCP)
+	}
+
+	soa := sc.C.Modules["dns_soa"].DNS
+	if soa == nil || soa.QueryName != "example.com" || soa.QueryType != "SOA" || soa.TransportProtocol != "tcp" || soa.QueryClass != "IN" || !soa.Recursion {
+		t.Fatalf("dns not decoded: %+v", soa)
+	}
+	if len(soa.ValidateAnswer.FailIfNoneMatchesRegexp) != 1 || len(soa.ValidateAuthority.FailIfMatchesRegexp) != 1 {
+		t.Errorf("dns rr validators not decoded: %+v", soa)
+	}
+
+	icmp := sc.C.Modules["icmp_df"].ICMP
+	if icmp == nil || icmp.IPProtocol != "ip4" || icmp.IPProtocolFallback || icmp.PayloadSize != 1472 || !icmp.DontFragment || icmp.TTL != 32 {
+		t.Fatalf("icmp not decoded: %+v", icmp)
+	}
+
+	grpc := sc.C.Modules["grpc_tls"].GRPC
+	if grpc == nil || grpc.Service != "helloworld.Greeter" || !grpc.TLS || !grpc.TLSConfig.InsecureSkipVerify || grpc.IPProtocol != "ip4" || !grpc.IPProtocolFallback {
+		t.Fatalf("grpc not decoded: %+v", grpc)
+	}
+
+	ws := sc.C.Modules["websocket_echo"].WebSocket
+	if ws == nil || ws.Headers["origin"] != "https://example.com" || len(ws.QueryResponse) != 1 || ws.QueryResponse[0].Send != "ping" || ws.QueryResponse[0].Expect.String() != "^pong$" {
+		t.Fatalf("websocket not decoded: %+v", ws)
+	}
+	if ws.HTTPClientConfig.Authorization == nil || ws.HTTPClientConfig.Authorization.Credentials != "secret" || !ws.HTTPClientConfig.FollowRedirects {
+		t.Errorf("websocket http_client_config not decoded: %+v", ws.HTTPClientConfig)
+	}
+
+	tlsProbe := sc.C.Modules["tls_cert"].TLS
+	if tlsProbe == nil || tlsProbe.TLSConfig.ServerName != "www.example.com" || tlsProbe.IPProtocol != "ip4" || !tlsProbe.IPProtocolFallback {
+		t.Fatalf("tls not decoded: %+v", tlsProbe)
+	}
+	if !tlsProbe.Revocation.CheckStapledOCSP || !tlsProbe.Revocation.QueryOCSPResponder || !tlsProbe.Revocation.QueryCRL {
+		t.Errorf("tls revocation not decoded: %+v", tlsProbe.Revocation)
+	}
+	if len(tlsProbe.CertificatePins.SPKISHA256) != 1 || tlsProbe.Validate("tls") != nil {
+		t.Errorf("tls certificate_pins not decoded: %+v", tlsProbe.CertificatePins)
+	}
+}
+
+func TestLoadBadConfigs(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"testdata/invalid-regexp.yaml", "could not compile regular expression"},
+		{"testdata/invalid-body-size-limit.yaml", "invalid size"},
+		{"testdata/unknown-key.yaml", "modules.http_2xx.http.http_client_config.basic_auth.user: unknown key"},
+		{"testdata/unknown-key.yaml", "modules.http_2xx.http.methd: unknown key"},
+		{"testdata/unknown-prober.yaml", `modules.http_2xx.prober: unknown prober "htp"`},
+		{"testdata/unknown-prober.yaml", "modules.http_missing_prober.prober: required"},
+		{"testdata/conflicting-ssl.yaml", "modules.http_ssl_probe.http.fail_if_ssl: conflicts with fail_if_not_ssl"},
+		{"testdata/conflicting-ssl.yaml", "modules.http_ssl_probe.http.required_alpn_protocol: conflicts with fail_if_ssl"},
+		{"testdata/invalid-tls-policy.yaml", `unknown TLS version "TLS14"`},
+		{"testdata/invalid-tls-policy.yaml", `unknown cipher suite "TLS_FOO"`},
+		{"testdata/invalid-status-code.yaml", "modules.http_2xx.http.valid_status_code[1]: invalid status code 2000"},
+		{"testdata/invalid-status-code.yaml", `modules.http_2xx.http.valid_http_versions[1]: invalid http version "HTTP/3"`},
+		{"testdata/invalid-status-code.yaml", `modules.http_2xx.http.valid_status_code[2]: invalid status code range "300-200"`},
+		{"testdata/duplicate-key.yaml", "modules.http_2xx.http.Method: duplicate key at line 6, first defined at line 5"},
+		{"testdata/invalid-compression.yaml", `modules.http_compressed.http.compression: unsupported compression "lzma"`},
+		{"testdata/invalid-compression.yaml", `modules.http_accept_encoding.http.headers.accept-encoding: conflicts with compression "gzip"`},
+		{"testdata/invalid-force-http2.yaml", "modules.http2_proxy.http.force_http2: not supported together with http_client_config.proxy_url"},
+		{"testdata/invalid-force-http2.yaml", `modules.http2_proxy.http.valid_http_versions: must contain "HTTP/2.0" when force_http2 is set`},
+		{"testdata/invalid-max-redirects.yaml", "modules.http_2xx.http.max_redirects: must not be negative"},
+		{"testdata/invalid-tcp.yaml", `modules.tcp_starttls.tcp.source_ip_address: invalid ip address "10.0.0"`},
+		{"testdata/invalid-tcp.yaml", "modules.tcp_starttls.tcp.query_response[0].starttls: connection is already using TLS"},
+		{"testdata/invalid-tcp.yaml", "modules.tcp_starttls.tcp.query_response[1]: one of expect, send or starttls is required"},
+		{"testdata/invalid-dns.yaml", "modules.dns_missing_query_name.dns.query_name: required"},
+		{"testdata/invalid-dns.yaml", `modules.dns_missing_query_name.dns.query_type: unknown query type "FOO"`},
+		{"testdata/invalid-dns.yaml", `modules.dns_missing_query_name.dns.query_class: unknown query class "XX"`},
+		{"testdata/invalid-dns.yaml", `modules.dns_missing_query_name.dns.transport_protocol: unsupported transport protocol "sctp"`},
+		{"testdata/invalid-dns.yaml", `modules.dns_missing_query_name.dns.valid_rcodes[0]: unknown rcode "NOERR"`},
+		{"testdata/invalid-dns.yaml", "modules.dns_over_udp.dns.dns_over_tls: requires transport_protocol tcp"},
+		{"testdata/invalid-dns.yaml", "modules.dns_missing_section.dns: required"},
+		{"testdata/invalid-grpc.yaml", "modules.grpc_plaintext.grpc.tls_config: requires tls: true"},
+		{"testdata/invalid-icmp.yaml", `modules.icmp_invalid.icmp.source_ip_address: invalid ip address "10.0.0"`},
+		{"testdata/invalid-icmp.yaml", "modules.icmp_invalid.icmp.payload_size: must be between 0 and 65000"},
+		{"testdata/invalid-icmp.yaml", "modules.icmp_invalid.icmp.ttl: must be between 0 and 255"},
+		{"testdata/invalid-icmp.yaml", "modules.icmp_invalid.icmp.dont_fragment: only supported with preferred_ip_protocol ip4 and ip_protocol_fallback: false"},
+		{"testdata/invalid-websocket.yaml", "modules.websocket_empty_step.websocket.query_response[1]: one of send or expect is required"},
+		{"testdata/invalid-fan-out.yaml", `modules.http_fan_out.http.fan_out.success_policy: unknown success policy "majority"`},
+		{"testdata/invalid-fan-out.yaml", "modules.http_fan_out.http.fan_out: requires skip_resolve_phase_with_proxy when proxy_url is set"},
+		{"testdata/invalid-pins.yaml", "modules.http_pinned.http.certificate_pins: conflicts with fail_if_ssl"},
+		{"testdata/invalid-pins.yaml", `modules.http_pinned.http.certificate_pins.spki_sha256[0]: invalid SHA-256 pin "sha256/not-a-pin"`},
+		{"testdata/invalid-pins.yaml", `modules.tls_pinned.tls.certificate_pins.fingerprint_sha256[0]: invalid SHA-256 pin "AB:CD"`},
+		{"testdata/missing-header.yaml", "modules.http_header.http.fail_if_header_matches[0].header: required"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			sc := &conf.SafeConfig{C: &conf.Config{}}
+			err := sc.ReloadConfig(test.input)
+			if err == nil {
+				t.Fatalf("Expected error loading %s", test.input)
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Errorf("Expected error containing %q, got %q", test.want, err)
+			}
+		})
+	}
+}
+
+func TestReloadKeepsPreviousConfig(t *testing.T) {
+	sc := &conf.SafeConfig{C: &conf.Config{}}
+	if err := sc.ReloadConfig("testdata/config-demo.yaml"); err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	previous := sc.C
+
+	if err := sc.ReloadConfig("testdata/conflicting-ssl.yaml"); err == nil {
+		t.Fatal("Expected error loading invalid config")
+	}
+	if sc.C != previous {
+		t.Error("Expected previous config to be kept after a failed reload")
+	}
+}
+
+func TestMarshalRedactsHeaders(t *testing.T) {
+	httpProbe := conf.NewDefaultHTTPProbe()
+	httpProbe.Headers = conf.Headers{"authorization": "Bearer s3cret", "Origin": "example.com"}
+	c := &conf.Config{Modules: map[string]conf.Module{
+		"http_2xx":  {Prober: "http", HTTP: httpProbe},
+		"websocket": {Prober: "websocket", WebSocket: &conf.WebSocketProbe{Headers: conf.Headers{"Cookie": "session=s3cret"}}},
+	}}
+
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		t.Fatalf("Error marshalling config: %v", err)
+	}
+	if strings.Contains(string(out), "s3cret") {
+		t.Errorf("Expected sensitive headers to be redacted, got:\n%s", out)
+	}
+	for _, want := range []string{"authorization: <secret>", "Cookie: <secret>", "Origin: example.com"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+	// 序列化不能修改探测时使用的header
+	if httpProbe.Headers["authorization"] != "Bearer s3cret" {
+		t.Errorf("Unexpected header value %q", httpProbe.Headers["authorization"])
+	}
+}
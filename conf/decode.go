@@ -0,0 +1,117 @@
+package conf
+
+import (
+	"fmt"
+	"github.com/alecthomas/units"
+	"github.com/mitchellh/mapstructure"
+	"github.com/prometheus/common/config"
+	"gopkg.in/yaml.v2"
+	"reflect"
+)
+
+// decodeHook viper 在 Unmarshal 时使用的 DecodeHook
+// viper 只能处理基础类型，这里补充 Regexp、IPProtocol、units.Base2Bytes 和 prometheus 的 HTTPClientConfig、TLSConfig
+// 并为各prober的配置填充默认值
+func decodeHook() mapstructure.DecodeHookFunc {
+	return mapstructure.ComposeDecodeHookFunc(baseDecodeHook(), probeDefaultsHook)
+}
+
+// baseDecodeHook 除填充默认值以外的所有hook
+func baseDecodeHook() mapstructure.DecodeHookFunc {
+	return mapstructure.ComposeDecodeHookFunc(
+		// viper 默认的两个hook
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+		// Regexp、IPProtocol、TLSVersion、StatusCodeMatcher 等实现了 encoding.TextUnmarshaler
+		mapstructure.TextUnmarshallerHookFunc(),
+		base2BytesHook,
+		statusCodeHook,
+		promConfigHook,
+	)
+}
+
+// decode 使用与 viper 相同的配置将 input 解析到 output
+func decode(hook mapstructure.DecodeHookFunc, input, output interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:       hook,
+		Result:           output,
+		WeaklyTypedInput: true,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(input)
+}
+
+// base2BytesHook 支持 body_size_limit: 1MiB 这样的写法
+func base2BytesHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.String || to != reflect.TypeOf(units.Base2Bytes(0)) {
+		return data, nil
+	}
+	b, err := units.ParseBase2Bytes(data.(string))
+	if err != nil {
+		return nil, fmt.Errorf("invalid size %q: %w", data, err)
+	}
+	return b, nil
+}
+
+// statusCodeHook 支持 valid_status_code 中直接写数字，e.g. [200, "3xx"]
+func statusCodeHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != reflect.TypeOf(StatusCodeMatcher{}) {
+		return data, nil
+	}
+	switch from.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return NewStatusCodeMatcher(fmt.Sprint(data))
+	}
+	return data, nil
+}
+
+// promConfigTypes 使用yaml tag的 prometheus common config 类型
+var promConfigTypes = map[reflect.Type]bool{
+	reflect.TypeOf(config.HTTPClientConfig{}): true,
+	reflect.TypeOf(config.TLSConfig{}):        true,
+}
+
+// promConfigHook HTTPClientConfig、TLSConfig 使用的是yaml tag，并且自带默认值和校验逻辑
+// 这里先将配置转回yaml，再交给它自己的 UnmarshalYAML 处理
+func promConfigHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.Map || !promConfigTypes[to] {
+		return data, nil
+	}
+	out, err := yaml.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	c := reflect.New(to)
+	if err := yaml.UnmarshalStrict(out, c.Interface()); err != nil {
+		return nil, err
+	}
+	return c.Elem().Interface(), nil
+}
+
+// probeDefaults 各prober配置的默认值，解析时在默认值的基础上覆盖配置中的字段，保证未配置的字段使用默认值
+var probeDefaults = map[reflect.Type]func() interface{}{
+	reflect.TypeOf(HTTPProbe{}):      func() interface{} { return NewDefaultHTTPProbe() },
+	reflect.TypeOf(TCPProbe{}):       func() interface{} { return NewDefaultTCPProbe() },
+	reflect.TypeOf(DNSProbe{}):       func() interface{} { return NewDefaultDNSProbe() },
+	reflect.TypeOf(ICMPProbe{}):      func() interface{} { return NewDefaultICMPProbe() },
+	reflect.TypeOf(GRPCProbe{}):      func() interface{} { return NewDefaultGRPCProbe() },
+	reflect.TypeOf(WebSocketProbe{}): func() interface{} { return NewDefaultWebSocketProbe() },
+	reflect.TypeOf(TLSProbe{}):       func() interface{} { return NewDefaultTLSProbe() },
+}
+
+// probeDefaultsHook 在 probeDefaults 的基础上解析prober配置
+// 解析时不再使用本hook，避免对同一类型再次触发
+func probeDefaultsHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	newDefault, ok := probeDefaults[to]
+	if from.Kind() != reflect.Map || !ok {
+		return data, nil
+	}
+	p := newDefault()
+	if err := decode(baseDecodeHook(), data, p); err != nil {
+		return nil, err
+	}
+	return reflect.ValueOf(p).Elem().Interface(), nil
+}
@@ -0,0 +1,79 @@
+package conf
+
+import (
+	"encoding"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"sort"
+)
+
+var (
+	confPkgPath       = reflect.TypeOf(Config{}).PkgPath()
+	yamlMarshalerType = reflect.TypeOf((*yaml.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// MarshalYAML 本包的结构体只有mapstructure tag，这里按照配置文件中的key输出
+func (c Config) MarshalYAML() (interface{}, error) {
+	return encodeStruct(reflect.ValueOf(c)), nil
+}
+
+// MarshalYAML 输出模块生效的配置（包括默认值），用于debug
+func (m Module) MarshalYAML() (interface{}, error) {
+	return encodeStruct(reflect.ValueOf(m)), nil
+}
+
+// encodeValue 将本包的结构体转换为 yaml.MapSlice，零值的字段不输出
+// 其它包的类型（e.g. HTTPClientConfig）以及自己实现了序列化的类型交给yaml处理
+func encodeValue(v reflect.Value) interface{} {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	t := v.Type()
+	if t.Implements(yamlMarshalerType) || t.Implements(textMarshalerType) {
+		return v.Interface()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if t.PkgPath() != confPkgPath {
+			return v.Interface()
+		}
+		return encodeStruct(v)
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		out := yaml.MapSlice{}
+		for _, key := range keys {
+			out = append(out, yaml.MapItem{Key: key.Interface(), Value: encodeValue(v.MapIndex(key))})
+		}
+		return out
+	case reflect.Slice:
+		out := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out = append(out, encodeValue(v.Index(i)))
+		}
+		return out
+	}
+	return v.Interface()
+}
+
+// encodeStruct 按照mapstructure tag输出结构体的非零值字段
+func encodeStruct(v reflect.Value) yaml.MapSlice {
+	t := v.Type()
+	out := yaml.MapSlice{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || v.Field(i).IsZero() {
+			continue
+		}
+		out = append(out, yaml.MapItem{Key: tagName(field), Value: encodeValue(v.Field(i))})
+	}
+	return out
+}
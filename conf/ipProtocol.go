@@ -3,6 +3,8 @@ package conf
 import (
 	"context"
 	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yuanyp8/http_exporter/utils"
 	"go.uber.org/zap"
 	"hash/fnv"
 	"net"
@@ -16,27 +18,91 @@ var (
 	IPV6 = IPProtocol("ip6")
 )
 
+type resolverKey struct{}
+
+// WithResolver 使用指定的resolver解析target，e.g. 测试时使用本地的dns服务器
+func WithResolver(ctx context.Context, resolver *net.Resolver) context.Context {
+	return context.WithValue(ctx, resolverKey{}, resolver)
+}
+
+// resolverFromContext 返回ctx中的resolver，ctx中没有时使用默认的resolver
+func resolverFromContext(ctx context.Context) *net.Resolver {
+	if resolver, ok := ctx.Value(resolverKey{}).(*net.Resolver); ok {
+		return resolver
+	}
+	return &net.Resolver{}
+}
+
+// UnmarshalText 实现 encoding.TextUnmarshaler，只允许 ip4/ip6
+func (p *IPProtocol) UnmarshalText(text []byte) error {
+	switch proto := IPProtocol(text); proto {
+	case IPV4, IPV6:
+		*p = proto
+		return nil
+	default:
+		return fmt.Errorf("invalid ip protocol %q, expected %q or %q", string(text), IPV4, IPV6)
+	}
+}
+
+// MarshalText 实现 encoding.TextMarshaler
+func (p IPProtocol) MarshalText() ([]byte, error) {
+	return []byte(p), nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (p *IPProtocol) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return p.UnmarshalText([]byte(s))
+}
+
 var IPProtocol2Gauge = map[IPProtocol]float64{
 	IPV4: 4,
 	IPV6: 6,
 }
 
 // ChooseProtocol 确定给定的target域名/ip对应的ip protocol
-func (h *HTTPProbe) ChooseProtocol(ctx context.Context, target string) (ip *net.IPAddr, lookupTime float64, err error) {
+// dns相关的metrics注册在本次探测的registry上，避免并发探测之间互相覆盖
+func (h *HTTPProbe) ChooseProtocol(ctx context.Context, target string, registry prometheus.Registerer) (ip *net.IPAddr, lookupTime float64, err error) {
+	return ChooseProtocol(ctx, h.IPProtocol, h.IPProtocolFallback, target, registry)
+}
+
+// ChooseProtocol 根据首选的ip protocol解析target，允许降级时会使用另一种协议的地址
+// 供所有需要解析目标地址的prober复用
+func ChooseProtocol(ctx context.Context, ipProtocol IPProtocol, fallbackIPProtocol bool, target string, registry prometheus.Registerer) (ip *net.IPAddr, lookupTime float64, err error) {
+	var (
+		probeDNSLookupTimeSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_dns_lookup_time_seconds",
+			Help: "Returns the time taken for probe dns lookup in seconds",
+		})
+
+		probeIPProtocolGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_ip_protocol",
+			Help: "Specifies whether probe ip protocol is IP4 or IP6",
+		})
+
+		probeIPAddrHash = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_ip_addr_hash",
+			Help: "Specifies the hash of IP address. It's useful to detect if the IP address changes.",
+		})
+	)
+	registry.MustRegister(probeDNSLookupTimeSeconds, probeIPProtocolGauge, probeIPAddrHash)
+	l := utils.LoggerFromContext(ctx, "Conf")
 
-	// registry.MustRegister(probeDNSLookupTimeSeconds, probeIPProtocolGauge, probeIPAddrHash)
-	protoStr := string(h.IPProtocol)
 	var fallbackProtocol IPProtocol
 	// 默认是IPV6，如失败则回滚到ipv4
-	switch h.IPProtocol {
+	switch ipProtocol {
 	case IPV6:
 		fallbackProtocol = IPV4
 	case IPV4:
 		fallbackProtocol = IPV6
 	default:
-		h.IPProtocol = IPV6
+		ipProtocol = IPV6
 		fallbackProtocol = IPV4
 	}
+	protoStr := string(ipProtocol)
 
 	l.Info("Resolving target address", zap.String("target", target), zap.String("ip_protocol", protoStr))
 
@@ -47,18 +113,25 @@ func (h *HTTPProbe) ChooseProtocol(ctx context.Context, target string) (ip *net.
 		probeDNSLookupTimeSeconds.Add(lookupTime)
 	}()
 
+	// 解析失败统一归为 dns_error
+	defer func() {
+		if err != nil {
+			utils.RecordFailure(ctx, utils.FailureDNSError)
+		}
+	}()
+
 	// 开始 dns 解析
-	resolver := &net.Resolver{}
+	resolver := resolverFromContext(ctx)
 
 	// 如果不允许协议降级，根据指定的协议进行处理，失败则返回
-	if !h.IPProtocolFallback {
+	if !fallbackIPProtocol {
 		// 基于给定的协议（ip/ip4/ip6）给出host对应的ip列表
 		ips, err := resolver.LookupIP(ctx, protoStr, target)
 		if err == nil {
 			for _, ip := range ips {
 				// 解析成功了,只要匹配到第一个ip就返回
 				l.Info("Resolved target address", zap.String("target", target), zap.String("ip", ip.String()))
-				probeIPProtocolGauge.Set(IPProtocol2Gauge[h.IPProtocol])
+				probeIPProtocolGauge.Set(IPProtocol2Gauge[ipProtocol])
 				probeIPAddrHash.Set(ipHash(ip))
 				return &net.IPAddr{IP: ip}, lookupTime, nil
 			}
@@ -80,7 +153,7 @@ func (h *HTTPProbe) ChooseProtocol(ctx context.Context, target string) (ip *net.
 	var fallback *net.IPAddr
 	for _, ip := range ips {
 		// 现在的ips列表不确定是ip4还是ip6解析成功了
-		switch h.IPProtocol {
+		switch ipProtocol {
 		case IPV4:
 			// To4()结果非空则证明为ip4地址
 			if ip.IP.To4() != nil {
@@ -105,19 +178,86 @@ func (h *HTTPProbe) ChooseProtocol(ctx context.Context, target string) (ip *net.
 		}
 	}
 	// Unable to find ip and no fallback set.
-	if fallback == nil || !h.IPProtocolFallback {
+	if fallback == nil {
 		return nil, 0.0, fmt.Errorf("unable to find ip; no fallback")
 	}
-	if fallbackProtocol == IPV4 {
-		probeIPProtocolGauge.Set(IPProtocol2Gauge[IPV4])
-	} else {
-		probeIPProtocolGauge.Set(IPProtocol2Gauge[IPV6])
-	}
+	probeIPProtocolGauge.Set(IPProtocol2Gauge[fallbackProtocol])
 	probeIPAddrHash.Set(ipHash(fallback.IP))
 	l.Info("Resolved target address", zap.String("target", target), zap.String("ip", fallback.String()))
 	return fallback, lookupTime, nil
 }
 
+// ResolveAll 解析target的所有地址，只返回首选ip protocol的地址
+// 允许降级且没有首选协议的地址时返回另一种协议的所有地址，供fan out模式逐个探测
+func ResolveAll(ctx context.Context, ipProtocol IPProtocol, fallbackIPProtocol bool, target string, registry prometheus.Registerer) (ips []net.IPAddr, lookupTime float64, err error) {
+	var (
+		probeDNSLookupTimeSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_dns_lookup_time_seconds",
+			Help: "Returns the time taken for probe dns lookup in seconds",
+		})
+
+		probeIPProtocolGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_ip_protocol",
+			Help: "Specifies whether probe ip protocol is IP4 or IP6",
+		})
+	)
+	registry.MustRegister(probeDNSLookupTimeSeconds, probeIPProtocolGauge)
+	l := utils.LoggerFromContext(ctx, "Conf")
+
+	fallbackProtocol := IPV4
+	if ipProtocol == IPV4 {
+		fallbackProtocol = IPV6
+	} else {
+		ipProtocol = IPV6
+	}
+
+	l.Info("Resolving all target addresses", zap.String("target", target), zap.String("ip_protocol", string(ipProtocol)))
+
+	resolveStart := time.Now()
+	defer func() {
+		lookupTime = time.Since(resolveStart).Seconds()
+		probeDNSLookupTimeSeconds.Add(lookupTime)
+	}()
+
+	defer func() {
+		if err != nil {
+			utils.RecordFailure(ctx, utils.FailureDNSError)
+		}
+	}()
+
+	// 不允许降级时只查询首选协议的记录
+	network := "ip"
+	if !fallbackIPProtocol {
+		network = string(ipProtocol)
+	}
+	addrs, err := resolverFromContext(ctx).LookupIP(ctx, network, target)
+	if err != nil {
+		l.Error("Resolution with IP protocol failed", zap.String("target", target), zap.String("ip_protocol", network), zap.Error(err))
+		return nil, 0.0, err
+	}
+
+	var fallback []net.IPAddr
+	for _, ip := range addrs {
+		if (ip.To4() != nil) == (ipProtocol == IPV4) {
+			ips = append(ips, net.IPAddr{IP: ip})
+		} else {
+			fallback = append(fallback, net.IPAddr{IP: ip})
+		}
+	}
+	protocol := ipProtocol
+	if len(ips) == 0 {
+		if len(fallback) == 0 {
+			return nil, 0.0, fmt.Errorf("unable to find ip; no fallback")
+		}
+		ips, protocol = fallback, fallbackProtocol
+	}
+	probeIPProtocolGauge.Set(IPProtocol2Gauge[protocol])
+	for _, ip := range ips {
+		l.Info("Resolved target address", zap.String("target", target), zap.String("ip", ip.String()))
+	}
+	return ips, lookupTime, nil
+}
+
 // 将IP地址进行Hash
 func ipHash(ip net.IP) float64 {
 	h := fnv.New32a()
@@ -3,7 +3,11 @@ package conf_test
 import (
 	"context"
 	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/utils"
 	"net"
+	"sync"
 	"testing"
 )
 
@@ -26,3 +30,143 @@ func TestGetIPAddr(t *testing.T) {
 		fmt.Println(ip, ip.String(), ip.To4(), ip.To16())
 	}
 }
+
+func TestChooseProtocol(t *testing.T) {
+	tests := []struct {
+		target        string
+		ipProtocol    conf.IPProtocol
+		fallback      bool
+		expectedIP    string
+		expectedProto float64
+	}{
+		{"127.0.0.1", conf.IPV4, false, "127.0.0.1", 4},
+		{"127.0.0.1", conf.IPV6, true, "127.0.0.1", 4},
+		{"::1", conf.IPV6, false, "::1", 6},
+		{"::1", conf.IPV4, true, "::1", 6},
+	}
+
+	var wg sync.WaitGroup
+	for _, test := range tests {
+		test := test
+		wg.Add(1)
+		// 并发探测时每个registry中的metrics互不影响
+		go func() {
+			defer wg.Done()
+			registry := prometheus.NewRegistry()
+			ip, _, err := conf.ChooseProtocol(context.Background(), test.ipProtocol, test.fallback, test.target, registry)
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.target, err)
+				return
+			}
+			if ip.String() != test.expectedIP {
+				t.Errorf("%s: expected ip %s, got %s", test.target, test.expectedIP, ip)
+			}
+
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			found := false
+			for _, mf := range mfs {
+				if mf.GetName() == "probe_ip_protocol" {
+					found = true
+					if got := mf.Metric[0].GetGauge().GetValue(); got != test.expectedProto {
+						t.Errorf("%s: expected probe_ip_protocol %v, got %v", test.target, test.expectedProto, got)
+					}
+				}
+			}
+			if !found {
+				t.Errorf("%s: probe_ip_protocol not registered", test.target)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestChooseProtocolWithoutFallback(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	ctx, failureReason := utils.NewFailureContext(context.Background())
+	if _, _, err := conf.ChooseProtocol(ctx, conf.IPV6, false, "127.0.0.1", registry); err == nil {
+		t.Error("Expected resolving an ip4 address with ip6 and no fallback to fail")
+	}
+	if reason := failureReason(); reason != utils.FailureDNSError {
+		t.Errorf("Expected failure reason %q, got %q", utils.FailureDNSError, reason)
+	}
+}
+
+func TestResolveAll(t *testing.T) {
+	tests := []struct {
+		target        string
+		ipProtocol    conf.IPProtocol
+		fallback      bool
+		expectedIPs   []string
+		expectedProto float64
+	}{
+		{"127.0.0.1", conf.IPV4, false, []string{"127.0.0.1"}, 4},
+		{"127.0.0.1", conf.IPV6, true, []string{"127.0.0.1"}, 4},
+		{"::1", conf.IPV6, false, []string{"::1"}, 6},
+		{"::1", conf.IPV4, true, []string{"::1"}, 6},
+	}
+
+	for _, test := range tests {
+		registry := prometheus.NewRegistry()
+		ips, _, err := conf.ResolveAll(context.Background(), test.ipProtocol, test.fallback, test.target, registry)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.target, err)
+		}
+		if len(ips) != len(test.expectedIPs) {
+			t.Fatalf("%s: expected ips %v, got %v", test.target, test.expectedIPs, ips)
+		}
+		for i, ip := range ips {
+			if ip.String() != test.expectedIPs[i] {
+				t.Errorf("%s: expected ips %v, got %v", test.target, test.expectedIPs, ips)
+			}
+		}
+
+		mfs, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, mf := range mfs {
+			if mf.GetName() == "probe_ip_protocol" {
+				if got := mf.Metric[0].GetGauge().GetValue(); got != test.expectedProto {
+					t.Errorf("%s: expected probe_ip_protocol %v, got %v", test.target, test.expectedProto, got)
+				}
+			}
+		}
+	}
+
+	ctx, failureReason := utils.NewFailureContext(context.Background())
+	if _, _, err := conf.ResolveAll(ctx, conf.IPV6, false, "127.0.0.1", prometheus.NewRegistry()); err == nil {
+		t.Error("Expected resolving an ip4 address with ip6 and no fallback to fail")
+	}
+	if reason := failureReason(); reason != utils.FailureDNSError {
+		t.Errorf("Expected failure reason %q, got %q", utils.FailureDNSError, reason)
+	}
+}
+
+func TestFanOutSucceeded(t *testing.T) {
+	tests := []struct {
+		policy    string
+		succeeded int
+		total     int
+		expected  bool
+	}{
+		{"", 3, 3, true},
+		{"", 2, 3, false},
+		{conf.FanOutPolicyAll, 2, 3, false},
+		{conf.FanOutPolicyAny, 1, 3, true},
+		{conf.FanOutPolicyAny, 0, 3, false},
+		{conf.FanOutPolicyQuorum, 2, 3, true},
+		{conf.FanOutPolicyQuorum, 2, 4, false},
+		{conf.FanOutPolicyAll, 0, 0, false},
+	}
+
+	for _, test := range tests {
+		f := conf.FanOutConfig{Enabled: true, SuccessPolicy: test.policy}
+		if got := f.Succeeded(test.succeeded, test.total); got != test.expected {
+			t.Errorf("%q %d/%d: expected %t, got %t", test.policy, test.succeeded, test.total, test.expected, got)
+		}
+	}
+}
@@ -3,6 +3,9 @@ package conf
 import (
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"reflect"
 	"sync"
 )
 
@@ -22,9 +25,15 @@ func C() *SafeConfig {
 type SafeConfig struct {
 	sync.RWMutex
 	C *Config
+
+	// 文件监听、SIGHUP、/-/reload 可能同时触发重载，保证同一时间只有一次重载
+	reloadMu sync.Mutex
 }
 
 func (sc *SafeConfig) ReloadConfig(configFile string) (err error) {
+	sc.reloadMu.Lock()
+	defer sc.reloadMu.Unlock()
+
 	c := &Config{}
 
 	// 配置文件加载完成后记录一次加载状态
@@ -37,18 +46,59 @@ func (sc *SafeConfig) ReloadConfig(configFile string) (err error) {
 		}
 	}()
 
+	// yaml中重复的key会被viper静默覆盖，需要在读取前检查
+	if ext := filepath.Ext(configFile); ext == ".yaml" || ext == ".yml" {
+		var content []byte
+		if content, err = os.ReadFile(configFile); err != nil {
+			l.Error("error loading config", zap.String("filePath", configFile), zap.Error(err))
+			return
+		}
+		if err = checkDuplicateKeys(content); err != nil {
+			l.Error("error validating config", zap.String("filePath", configFile), zap.Error(err))
+			return
+		}
+	}
+
 	// load config from the given pathname file
 	vip := viper.New()
 	vip.SetConfigFile(configFile)
 
 	if err = vip.ReadInConfig(); err != nil {
-		l.Error("error loading config", zap.String("filePath", configFile))
+		l.Error("error loading config", zap.String("filePath", configFile), zap.Error(err))
 		return
 	}
-	if err = vip.Unmarshal(c); err != nil {
-		l.Error("error unmarshal config", zap.String("filePath", configFile))
+	if err = checkUnknownKeys("", vip.AllSettings(), reflect.TypeOf(Config{})); err != nil {
+		l.Error("error validating config", zap.String("filePath", configFile), zap.Error(err))
 		return
 	}
+	if err = vip.Unmarshal(c, viper.DecodeHook(decodeHook())); err != nil {
+		l.Error("error unmarshal config", zap.String("filePath", configFile), zap.Error(err))
+		return
+	}
+	// 校验失败时保留之前的配置
+	if err = c.Validate(); err != nil {
+		l.Error("error validating config", zap.String("filePath", configFile), zap.Error(err))
+		return
+	}
+
+	// 未配置探测段的模块使用对应prober的默认配置
+	for name, module := range c.Modules {
+		switch {
+		case module.Prober == "http" && module.HTTP == nil:
+			module.HTTP = NewDefaultHTTPProbe()
+		case module.Prober == "tcp" && module.TCP == nil:
+			module.TCP = NewDefaultTCPProbe()
+		case module.Prober == "icmp" && module.ICMP == nil:
+			module.ICMP = NewDefaultICMPProbe()
+		case module.Prober == "grpc" && module.GRPC == nil:
+			module.GRPC = NewDefaultGRPCProbe()
+		case module.Prober == "websocket" && module.WebSocket == nil:
+			module.WebSocket = NewDefaultWebSocketProbe()
+		case module.Prober == "tls" && module.TLS == nil:
+			module.TLS = NewDefaultTLSProbe()
+		}
+		c.Modules[name] = module
+	}
 
 	sc.Lock()
 	sc.C = c
@@ -14,28 +14,10 @@ var (
 		Name:      "config_last_reload_success_timestamp_seconds",
 		Help:      "Timestamp of the last successful configuration reload.",
 	})
-
-	probeDNSLookupTimeSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "probe_dns_lookup_time_seconds",
-		Help: "Returns the time taken for probe dns lookup in seconds",
-	})
-
-	probeIPProtocolGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "probe_ip_protocol",
-		Help: "Specifies whether probe ip protocol is IP4 or IP6",
-	})
-
-	probeIPAddrHash = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "probe_ip_addr_hash",
-		Help: "Specifies the hash of IP address. It's useful to detect if the IP address changes.",
-	})
 )
 
 func init() {
 	prometheus.MustRegister(configReloadSuccess,
 		configReloadSeconds,
-		probeDNSLookupTimeSeconds,
-		probeIPProtocolGauge,
-		probeIPAddrHash,
 	)
 }
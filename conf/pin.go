@@ -0,0 +1,70 @@
+package conf
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"go.uber.org/multierr"
+	"strings"
+)
+
+// PinConfig 证书锁定，配置后服务端证书链中必须有证书匹配其中一个pin，否则探测失败
+type PinConfig struct {
+	SPKISHA256        []string `mapstructure:"spki_sha256"`        // 证书公钥(SubjectPublicKeyInfo)的SHA-256，hex或base64 e.g. sha256/YLh1dUR9y6Kja30RrAn7JKnbQG/uEtLMkBgFF2Fuihg=
+	FingerprintSHA256 []string `mapstructure:"fingerprint_sha256"` // 整张证书的SHA-256，与 probe_ssl_last_chain_info 的 fingerprint_sha256 一致，允许使用冒号分隔
+}
+
+// Enabled 是否配置了pin
+func (p PinConfig) Enabled() bool {
+	return len(p.SPKISHA256) > 0 || len(p.FingerprintSHA256) > 0
+}
+
+// Validate 校验每个pin都是合法的SHA-256摘要
+func (p PinConfig) Validate(path string) (err error) {
+	for i, pin := range p.SPKISHA256 {
+		if _, e := decodePin(pin); e != nil {
+			err = multierr.Append(err, pathError(fmt.Sprintf("%s.spki_sha256[%d]", path, i), "%s", e))
+		}
+	}
+	for i, pin := range p.FingerprintSHA256 {
+		if _, e := decodePin(pin); e != nil {
+			err = multierr.Append(err, pathError(fmt.Sprintf("%s.fingerprint_sha256[%d]", path, i), "%s", e))
+		}
+	}
+	return
+}
+
+// Digests 返回解析后的pin，配置已经过校验，忽略无法解析的pin
+func (p PinConfig) Digests() (spki, fingerprint [][]byte) {
+	return decodePins(p.SPKISHA256), decodePins(p.FingerprintSHA256)
+}
+
+// decodePin 解析hex(允许冒号分隔)或base64格式的SHA-256摘要，base64可以带有 sha256/ 前缀
+func decodePin(pin string) ([]byte, error) {
+	s := strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+	var (
+		digest []byte
+		err    error
+	)
+	if hexPin := strings.ReplaceAll(s, ":", ""); len(hexPin) == sha256.Size*2 {
+		digest, err = hex.DecodeString(hexPin)
+	} else {
+		digest, err = base64.StdEncoding.DecodeString(s)
+	}
+	if err != nil || len(digest) != sha256.Size {
+		return nil, fmt.Errorf("invalid SHA-256 pin %q", pin)
+	}
+	return digest, nil
+}
+
+// decodePins 解析所有pin
+func decodePins(pins []string) [][]byte {
+	digests := make([][]byte, 0, len(pins))
+	for _, pin := range pins {
+		if digest, err := decodePin(pin); err == nil {
+			digests = append(digests, digest)
+		}
+	}
+	return digests
+}
@@ -0,0 +1,13 @@
+package conf
+
+// RevocationConfig 叶子证书的吊销检查，所有检查都需要显式开启
+type RevocationConfig struct {
+	CheckStapledOCSP   bool `mapstructure:"check_stapled_ocsp"`   // 检查握手时服务端stapled的OCSP响应
+	QueryOCSPResponder bool `mapstructure:"query_ocsp_responder"` // 向叶子证书中的OCSP地址查询
+	QueryCRL           bool `mapstructure:"query_crl"`            // 下载叶子证书中的CRL分发点
+}
+
+// Enabled 是否开启了任意一项吊销检查
+func (r RevocationConfig) Enabled() bool {
+	return r.CheckStapledOCSP || r.QueryOCSPResponder || r.QueryCRL
+}
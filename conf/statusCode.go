@@ -0,0 +1,131 @@
+package conf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StatusCodeMatcher 状态码匹配规则
+// 支持 200（单个状态码）、2xx（一类状态码）、200-399（闭区间）以及前缀 ! 表示排除，e.g. !404
+type StatusCodeMatcher struct {
+	min, max int
+	negate   bool
+	origin   string
+}
+
+// NewStatusCodeMatcher 解析状态码匹配规则
+func NewStatusCodeMatcher(rule string) (*StatusCodeMatcher, error) {
+	m := &StatusCodeMatcher{origin: rule}
+	expr := strings.TrimSpace(rule)
+	if strings.HasPrefix(expr, "!") {
+		m.negate = true
+		expr = strings.TrimSpace(expr[1:])
+	}
+
+	var err error
+	switch {
+	case len(expr) == 3 && strings.HasSuffix(strings.ToLower(expr), "xx"):
+		// 2xx => [200, 299]
+		var class int
+		if class, err = strconv.Atoi(expr[:1]); err != nil {
+			return nil, fmt.Errorf("invalid status code class %q", rule)
+		}
+		m.min, m.max = class*100, class*100+99
+	case strings.Contains(expr, "-"):
+		// 200-399 => [200, 399]
+		bounds := strings.SplitN(expr, "-", 2)
+		if m.min, err = strconv.Atoi(strings.TrimSpace(bounds[0])); err != nil {
+			return nil, fmt.Errorf("invalid status code range %q", rule)
+		}
+		if m.max, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil {
+			return nil, fmt.Errorf("invalid status code range %q", rule)
+		}
+	default:
+		if m.min, err = strconv.Atoi(expr); err != nil {
+			return nil, fmt.Errorf("invalid status code %q", rule)
+		}
+		m.max = m.min
+	}
+	return m, nil
+}
+
+// MustNewStatusCodeMatcher works like NewStatusCodeMatcher, but panics if the rule can not be parsed.
+func MustNewStatusCodeMatcher(rule string) StatusCodeMatcher {
+	m, err := NewStatusCodeMatcher(rule)
+	if err != nil {
+		panic(err)
+	}
+	return *m
+}
+
+// contains 状态码是否落在规则的范围内，不考虑 !
+func (m StatusCodeMatcher) contains(code int) bool {
+	return m.min <= code && code <= m.max
+}
+
+func (m StatusCodeMatcher) String() string {
+	return m.origin
+}
+
+// UnmarshalText 实现 encoding.TextUnmarshaler
+func (m *StatusCodeMatcher) UnmarshalText(text []byte) error {
+	matcher, err := NewStatusCodeMatcher(string(text))
+	if err != nil {
+		return err
+	}
+	*m = *matcher
+	return nil
+}
+
+// MarshalText 实现 encoding.TextMarshaler
+func (m StatusCodeMatcher) MarshalText() ([]byte, error) {
+	return []byte(m.origin), nil
+}
+
+// validate 检查规则中的状态码是否合法
+func (m StatusCodeMatcher) validate() error {
+	if m.min == m.max && (m.min < 100 || m.min > 599) {
+		return fmt.Errorf("invalid status code %d", m.min)
+	}
+	if m.min < 100 || m.max > 599 || m.min > m.max {
+		return fmt.Errorf("invalid status code range %q", m.origin)
+	}
+	return nil
+}
+
+// StatusCodeMatchers 一组状态码匹配规则
+// 状态码需要命中至少一条普通规则，并且不能命中任何一条 ! 规则；没有普通规则时默认为 2xx
+type StatusCodeMatchers []StatusCodeMatcher
+
+// defaultStatusCodeMatcher 未配置状态码规则时使用
+var defaultStatusCodeMatcher = MustNewStatusCodeMatcher("2xx")
+
+// Match 判断状态码是否有效，无效时返回拒绝该状态码的规则
+func (ms StatusCodeMatchers) Match(code int) (bool, string) {
+	var positives []string
+	matched := false
+	for _, m := range ms {
+		if m.negate {
+			if m.contains(code) {
+				return false, m.String()
+			}
+			continue
+		}
+		positives = append(positives, m.String())
+		if m.contains(code) {
+			matched = true
+		}
+	}
+
+	if len(positives) == 0 {
+		if defaultStatusCodeMatcher.contains(code) {
+			return true, ""
+		}
+		return false, defaultStatusCodeMatcher.String()
+	}
+	if !matched {
+		return false, strings.Join(positives, ",")
+	}
+	return true, ""
+}
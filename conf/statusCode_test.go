@@ -0,0 +1,51 @@
+package conf_test
+
+import (
+	"github.com/yuanyp8/http_exporter/conf"
+	"testing"
+)
+
+func TestStatusCodeMatchers(t *testing.T) {
+	tests := []struct {
+		rules      []string
+		code       int
+		valid      bool
+		rejectedBy string
+	}{
+		// 未配置规则时默认2xx
+		{nil, 200, true, ""},
+		{nil, 199, false, "2xx"},
+		{nil, 20, false, "2xx"},
+		{[]string{"200"}, 200, true, ""},
+		{[]string{"200"}, 201, false, "200"},
+		{[]string{"2xx"}, 299, true, ""},
+		{[]string{"2XX", "3xx"}, 302, true, ""},
+		{[]string{"200-399"}, 399, true, ""},
+		{[]string{"200-399"}, 400, false, "200-399"},
+		{[]string{"!404"}, 200, true, ""},
+		{[]string{"!404"}, 404, false, "!404"},
+		// 只有排除规则时其余状态码仍然需要是2xx
+		{[]string{"!404"}, 500, false, "2xx"},
+		{[]string{"2xx", "3xx", "!304"}, 304, false, "!304"},
+		{[]string{"200-499", "!4xx"}, 401, false, "!4xx"},
+	}
+
+	for _, test := range tests {
+		var matchers conf.StatusCodeMatchers
+		for _, rule := range test.rules {
+			matchers = append(matchers, conf.MustNewStatusCodeMatcher(rule))
+		}
+		valid, rejectedBy := matchers.Match(test.code)
+		if valid != test.valid || rejectedBy != test.rejectedBy {
+			t.Errorf("%v.Match(%d) = (%t, %q), want (%t, %q)", test.rules, test.code, valid, rejectedBy, test.valid, test.rejectedBy)
+		}
+	}
+}
+
+func TestInvalidStatusCodeMatcher(t *testing.T) {
+	for _, rule := range []string{"", "abc", "2x", "x00", "200-", "-200", "!"} {
+		if _, err := conf.NewStatusCodeMatcher(rule); err == nil {
+			t.Errorf("Expected error parsing status code rule %q", rule)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package conf
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/prometheus/common/config"
+)
+
+// TLSVersion 支持 TLS10/TLS11/TLS12/TLS13 的写法，与 http_client_config.tls_config.min_version 保持一致
+type TLSVersion uint16
+
+// UnmarshalText 实现 encoding.TextUnmarshaler
+func (v *TLSVersion) UnmarshalText(text []byte) error {
+	tv, ok := config.TLSVersions[string(text)]
+	if !ok {
+		return fmt.Errorf("unknown TLS version %q", string(text))
+	}
+	*v = TLSVersion(tv)
+	return nil
+}
+
+// MarshalText 实现 encoding.TextMarshaler
+func (v TLSVersion) MarshalText() ([]byte, error) {
+	return []byte(v.String()), nil
+}
+
+func (v TLSVersion) String() string {
+	for s, tv := range config.TLSVersions {
+		if TLSVersion(tv) == v {
+			return s
+		}
+	}
+	return fmt.Sprintf("%d", uint16(v))
+}
+
+// TLSCipherSuite 使用IANA名称配置的加密套件，e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+type TLSCipherSuite uint16
+
+// UnmarshalText 实现 encoding.TextUnmarshaler
+func (c *TLSCipherSuite) UnmarshalText(text []byte) error {
+	for _, suites := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, suite := range suites {
+			if suite.Name == string(text) {
+				*c = TLSCipherSuite(suite.ID)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("unknown cipher suite %q", string(text))
+}
+
+// MarshalText 实现 encoding.TextMarshaler
+func (c TLSCipherSuite) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c TLSCipherSuite) String() string {
+	return tls.CipherSuiteName(uint16(c))
+}
@@ -0,0 +1,434 @@
+package conf
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/prometheus/common/config"
+	"go.uber.org/multierr"
+	"gopkg.in/yaml.v3"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// validProbers 目前支持的prober类型
+var validProbers = map[string]bool{
+	"http":      true,
+	"tcp":       true,
+	"dns":       true,
+	"icmp":      true,
+	"grpc":      true,
+	"websocket": true,
+	"tls":       true,
+}
+
+// validHTTPVersions valid_http_versions 允许的取值
+var validHTTPVersions = map[string]bool{
+	"HTTP/1.0": true,
+	"HTTP/1.1": true,
+	"HTTP/2.0": true,
+}
+
+// validCompressions compression 允许的取值
+var validCompressions = map[string]bool{
+	"":         true,
+	"identity": true,
+	"gzip":     true,
+	"deflate":  true,
+	"br":       true,
+	"zstd":     true,
+}
+
+// validFanOutPolicies fan_out.success_policy 允许的取值，为空时等同于all
+var validFanOutPolicies = map[string]bool{
+	"":                 true,
+	FanOutPolicyAll:    true,
+	FanOutPolicyAny:    true,
+	FanOutPolicyQuorum: true,
+}
+
+// pathError 生成带有配置路径的错误，e.g. modules.http_2xx.prober: required
+func pathError(path, format string, args ...interface{}) error {
+	return fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...))
+}
+
+// Validate 校验配置的语义是否合法，返回所有发现的错误
+func (c *Config) Validate() (err error) {
+	for _, name := range sortedKeys(c.Modules) {
+		err = multierr.Append(err, c.Modules[name].Validate("modules."+name))
+	}
+	return
+}
+
+// Validate 校验单个模块，path为该模块在配置文件中的路径
+func (m Module) Validate(path string) (err error) {
+	err = validateRequired(path, m)
+	if m.Prober != "" && !validProbers[m.Prober] {
+		err = multierr.Append(err, pathError(path+".prober", "unknown prober %q", m.Prober))
+	}
+	if m.Timeout < 0 {
+		err = multierr.Append(err, pathError(path+".timeout", "must not be negative"))
+	}
+	if m.HTTP != nil {
+		err = multierr.Append(err, m.HTTP.Validate(path+".http"))
+	}
+	if m.TCP != nil {
+		err = multierr.Append(err, m.TCP.Validate(path+".tcp"))
+	}
+	if m.DNS != nil {
+		err = multierr.Append(err, m.DNS.Validate(path+".dns"))
+	} else if m.Prober == "dns" {
+		// dns探测至少需要配置 query_name
+		err = multierr.Append(err, pathError(path+".dns", "required"))
+	}
+	if m.ICMP != nil {
+		err = multierr.Append(err, m.ICMP.Validate(path+".icmp"))
+	}
+	if m.GRPC != nil {
+		err = multierr.Append(err, m.GRPC.Validate(path+".grpc"))
+	}
+	if m.WebSocket != nil {
+		err = multierr.Append(err, m.WebSocket.Validate(path+".websocket"))
+	}
+	if m.TLS != nil {
+		err = multierr.Append(err, m.TLS.Validate(path+".tls"))
+	}
+	return
+}
+
+// Validate 校验icmp探测配置
+func (i *ICMPProbe) Validate(path string) (err error) {
+	if i.SourceIPAddress != "" && net.ParseIP(i.SourceIPAddress) == nil {
+		err = multierr.Append(err, pathError(path+".source_ip_address", "invalid ip address %q", i.SourceIPAddress))
+	}
+	// 数据部分加上ip头和icmp头不能超过ip报文的最大长度
+	if i.PayloadSize < 0 || i.PayloadSize > 65000 {
+		err = multierr.Append(err, pathError(path+".payload_size", "must be between 0 and 65000"))
+	}
+	if i.TTL < 0 || i.TTL > 255 {
+		err = multierr.Append(err, pathError(path+".ttl", "must be between 0 and 255"))
+	}
+	if i.DontFragment && (i.IPProtocol == IPV6 || i.IPProtocolFallback) {
+		err = multierr.Append(err, pathError(path+".dont_fragment", "only supported with preferred_ip_protocol ip4 and ip_protocol_fallback: false"))
+	}
+	return
+}
+
+// Validate 校验dns探测配置
+func (d *DNSProbe) Validate(path string) (err error) {
+	err = validateRequired(path, *d)
+	if d.SourceIPAddress != "" && net.ParseIP(d.SourceIPAddress) == nil {
+		err = multierr.Append(err, pathError(path+".source_ip_address", "invalid ip address %q", d.SourceIPAddress))
+	}
+	switch d.TransportProtocol {
+	case "tcp":
+	case "udp":
+		if d.DNSOverTLS {
+			err = multierr.Append(err, pathError(path+".dns_over_tls", "requires transport_protocol tcp"))
+		}
+	default:
+		err = multierr.Append(err, pathError(path+".transport_protocol", "unsupported transport protocol %q", d.TransportProtocol))
+	}
+	if _, ok := dns.StringToType[strings.ToUpper(d.QueryType)]; !ok {
+		err = multierr.Append(err, pathError(path+".query_type", "unknown query type %q", d.QueryType))
+	}
+	if _, ok := dns.StringToClass[strings.ToUpper(d.QueryClass)]; !ok {
+		err = multierr.Append(err, pathError(path+".query_class", "unknown query class %q", d.QueryClass))
+	}
+	for i, rcode := range d.ValidRcodes {
+		if _, ok := dns.StringToRcode[strings.ToUpper(rcode)]; !ok {
+			err = multierr.Append(err, pathError(fmt.Sprintf("%s.valid_rcodes[%d]", path, i), "unknown rcode %q", rcode))
+		}
+	}
+	return
+}
+
+// Validate 校验tcp探测配置
+func (t *TCPProbe) Validate(path string) (err error) {
+	if t.SourceIPAddress != "" && net.ParseIP(t.SourceIPAddress) == nil {
+		err = multierr.Append(err, pathError(path+".source_ip_address", "invalid ip address %q", t.SourceIPAddress))
+	}
+	// 已经是TLS连接时不能再STARTTLS
+	tls := t.TLS
+	for i, qr := range t.QueryResponse {
+		qrPath := fmt.Sprintf("%s.query_response[%d]", path, i)
+		if qr.Expect.Regexp == nil && qr.Send == "" && !qr.StartTLS {
+			err = multierr.Append(err, pathError(qrPath, "one of expect, send or starttls is required"))
+		}
+		if qr.StartTLS {
+			if tls {
+				err = multierr.Append(err, pathError(qrPath+".starttls", "connection is already using TLS"))
+			}
+			tls = true
+		}
+	}
+	return
+}
+
+// Validate 校验grpc探测配置
+func (g *GRPCProbe) Validate(path string) (err error) {
+	// 明文连接时tls_config不会生效，避免误以为开启了TLS
+	if !g.TLS && g.TLSConfig != (config.TLSConfig{}) {
+		err = multierr.Append(err, pathError(path+".tls_config", "requires tls: true"))
+	}
+	return
+}
+
+// Validate 校验websocket探测配置
+func (w *WebSocketProbe) Validate(path string) (err error) {
+	for i, qr := range w.QueryResponse {
+		if qr.Expect.Regexp == nil && qr.Send == "" {
+			err = multierr.Append(err, pathError(fmt.Sprintf("%s.query_response[%d]", path, i), "one of send or expect is required"))
+		}
+	}
+	return
+}
+
+// Validate 校验http探测配置
+func (h *HTTPProbe) Validate(path string) (err error) {
+	if h.FailIfSSL && h.FailIfNotSSL {
+		err = multierr.Append(err, pathError(path+".fail_if_ssl", "conflicts with fail_if_not_ssl"))
+	}
+	if h.FailIfSSL {
+		// 要求不使用TLS时，TLS相关的策略没有意义
+		if h.FailIfTLSVersionBelow != 0 {
+			err = multierr.Append(err, pathError(path+".fail_if_tls_version_below", "conflicts with fail_if_ssl"))
+		}
+		if len(h.AllowedCipherSuites) > 0 {
+			err = multierr.Append(err, pathError(path+".allowed_cipher_suites", "conflicts with fail_if_ssl"))
+		}
+		if h.RequiredALPNProtocol != "" {
+			err = multierr.Append(err, pathError(path+".required_alpn_protocol", "conflicts with fail_if_ssl"))
+		}
+	}
+	for i, m := range h.ValidStatusCode {
+		if e := m.validate(); e != nil {
+			err = multierr.Append(err, pathError(fmt.Sprintf("%s.valid_status_code[%d]", path, i), "%s", e))
+		}
+	}
+	for i, m := range h.ValidRedirectStatusCode {
+		if e := m.validate(); e != nil {
+			err = multierr.Append(err, pathError(fmt.Sprintf("%s.valid_redirect_status_code[%d]", path, i), "%s", e))
+		}
+	}
+	for i, version := range h.ValidHTTPVersions {
+		if !validHTTPVersions[version] {
+			err = multierr.Append(err, pathError(fmt.Sprintf("%s.valid_http_versions[%d]", path, i), "invalid http version %q", version))
+		}
+	}
+	if h.ForceHTTP2 {
+		if h.HTTPClientConfig.ProxyURL.URL != nil {
+			err = multierr.Append(err, pathError(path+".force_http2", "not supported together with http_client_config.proxy_url"))
+		}
+		if h.HTTPClientConfig.OAuth2 != nil {
+			err = multierr.Append(err, pathError(path+".force_http2", "not supported together with http_client_config.oauth2"))
+		}
+		if !h.HTTPClientConfig.EnableHTTP2 {
+			err = multierr.Append(err, pathError(path+".force_http2", "conflicts with http_client_config.enable_http2: false"))
+		}
+		if len(h.ValidHTTPVersions) > 0 && !contains(h.ValidHTTPVersions, "HTTP/2.0") {
+			err = multierr.Append(err, pathError(path+".valid_http_versions", "must contain \"HTTP/2.0\" when force_http2 is set"))
+		}
+	}
+	if !validCompressions[strings.ToLower(h.Compression)] {
+		err = multierr.Append(err, pathError(path+".compression", "unsupported compression %q", h.Compression))
+	}
+	for _, name := range sortedKeys(h.Headers) {
+		// compression 会设置 Accept-Encoding，两者不一致时无法确定按什么算法解压
+		if strings.EqualFold(name, "Accept-Encoding") && h.Compression != "" && !strings.EqualFold(h.Headers[name], h.Compression) {
+			err = multierr.Append(err, pathError(path+".headers."+name, "conflicts with compression %q", h.Compression))
+		}
+	}
+	if h.MaxRedirects < 0 {
+		err = multierr.Append(err, pathError(path+".max_redirects", "must not be negative"))
+	}
+	if h.BodySizeLimit < 0 {
+		err = multierr.Append(err, pathError(path+".body_size_limit", "must not be negative"))
+	}
+	for i, hm := range h.FailIfHeaderMatchesRegexp {
+		err = multierr.Append(err, validateRequired(fmt.Sprintf("%s.fail_if_header_matches[%d]", path, i), hm))
+	}
+	for i, hm := range h.FailIfHeaderNotMatchesRegexp {
+		err = multierr.Append(err, validateRequired(fmt.Sprintf("%s.fail_if_header_not_matches[%d]", path, i), hm))
+	}
+	if h.FailIfSSL && h.CertificatePins.Enabled() {
+		err = multierr.Append(err, pathError(path+".certificate_pins", "conflicts with fail_if_ssl"))
+	}
+	err = multierr.Append(err, h.CertificatePins.Validate(path+".certificate_pins"))
+	if !validFanOutPolicies[h.FanOut.SuccessPolicy] {
+		err = multierr.Append(err, pathError(path+".fan_out.success_policy", "unknown success policy %q", h.FanOut.SuccessPolicy))
+	}
+	// 通过代理解析域名时无法得知target的所有地址
+	if h.FanOut.Enabled && h.HTTPClientConfig.ProxyURL.URL != nil && !h.SkipResolvePhaseWithProxy {
+		err = multierr.Append(err, pathError(path+".fan_out", "requires skip_resolve_phase_with_proxy when proxy_url is set"))
+	}
+	return
+}
+
+// Validate 校验tls探测配置
+func (t *TLSProbe) Validate(path string) error {
+	return t.CertificatePins.Validate(path + ".certificate_pins")
+}
+
+// validateRequired 检查带有 validate:"required" tag 的字段是否为零值
+func validateRequired(path string, v interface{}) (err error) {
+	val := reflect.ValueOf(v)
+	t := val.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("validate") != "required" || !val.Field(i).IsZero() {
+			continue
+		}
+		err = multierr.Append(err, pathError(path+"."+tagName(field), "required"))
+	}
+	return
+}
+
+// checkUnknownKeys 对照结构体的tag，检查配置文件中无法识别的key
+// viper 会将key统一转为小写，因此比较时忽略大小写
+func checkUnknownKeys(path string, data interface{}, t reflect.Type) (err error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := data.(map[string]interface{})
+		if !ok {
+			// 非map的值由对应的decode hook处理，e.g. Regexp
+			return nil
+		}
+		fields := structFields(t)
+		for _, key := range sortedKeys(m) {
+			field, ok := fields[strings.ToLower(key)]
+			if !ok {
+				err = multierr.Append(err, pathError(joinPath(path, key), "unknown key"))
+				continue
+			}
+			err = multierr.Append(err, checkUnknownKeys(joinPath(path, key), m[key], field.Type))
+		}
+
+	case reflect.Map:
+		m, ok := data.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for _, key := range sortedKeys(m) {
+			err = multierr.Append(err, checkUnknownKeys(joinPath(path, key), m[key], t.Elem()))
+		}
+
+	case reflect.Slice:
+		s, ok := data.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, item := range s {
+			err = multierr.Append(err, checkUnknownKeys(fmt.Sprintf("%s[%d]", path, i), item, t.Elem()))
+		}
+	}
+	return
+}
+
+// structFields 返回结构体的 key => field 映射
+// 本包的结构体使用mapstructure tag，其它包（e.g. prometheus common config）使用yaml tag
+func structFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := tagName(field)
+		if name == "-" {
+			continue
+		}
+		if name == "" && field.Anonymous {
+			// inline 的结构体，字段平铺到上一层
+			for k, v := range structFields(field.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+		fields[strings.ToLower(name)] = field
+	}
+	return fields
+}
+
+// tagName 返回字段在配置文件中的key
+func tagName(field reflect.StructField) string {
+	tag, ok := field.Tag.Lookup("mapstructure")
+	if !ok {
+		tag = field.Tag.Get("yaml")
+	}
+	name := strings.Split(tag, ",")[0]
+	if name == "" && !strings.Contains(tag, "inline") && !strings.Contains(tag, "squash") {
+		name = strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// checkDuplicateKeys 检查yaml配置文件中重复的key
+// viper 对key大小写不敏感，因此 Method 和 method 也视为重复
+func checkDuplicateKeys(content []byte) error {
+	var root yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	if err := decoder.Decode(&root); err != nil {
+		// 语法错误交给viper报告
+		return nil
+	}
+	return duplicateKeys("", &root)
+}
+
+func duplicateKeys(path string, node *yaml.Node) (err error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, n := range node.Content {
+			err = multierr.Append(err, duplicateKeys(path, n))
+		}
+	case yaml.MappingNode:
+		seen := make(map[string]int)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keyPath := joinPath(path, key.Value)
+			if line, ok := seen[strings.ToLower(key.Value)]; ok {
+				err = multierr.Append(err, pathError(keyPath, "duplicate key at line %d, first defined at line %d", key.Line, line))
+				continue
+			}
+			seen[strings.ToLower(key.Value)] = key.Line
+			err = multierr.Append(err, duplicateKeys(keyPath, value))
+		}
+	case yaml.SequenceNode:
+		for i, n := range node.Content {
+			err = multierr.Append(err, duplicateKeys(fmt.Sprintf("%s[%d]", path, i), n))
+		}
+	}
+	return
+}
+
+func contains(s []string, v string) bool {
+	for _, item := range s {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// sortedKeys 保证错误信息的顺序稳定
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
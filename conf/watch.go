@@ -0,0 +1,75 @@
+package conf
+
+import (
+	"context"
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+	"path/filepath"
+	"time"
+)
+
+// Watch 监听配置文件的变化并自动重载，直到ctx结束
+// 监听的是配置文件所在的目录，这样编辑器的 rename 保存和 k8s ConfigMap 的软链接切换也能被感知
+// 短时间内的多次修改只会在最后一次修改 debounce 之后触发一次重载
+func (sc *SafeConfig) Watch(ctx context.Context, configFile string, debounce time.Duration) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	configFile, err = filepath.Abs(configFile)
+	if err != nil {
+		return err
+	}
+	if err = watcher.Add(filepath.Dir(configFile)); err != nil {
+		return err
+	}
+	realConfigFile, _ := filepath.EvalSymlinks(configFile)
+
+	l.Info("Watching config file for changes", zap.String("filePath", configFile), zap.Duration("debounce", debounce))
+
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			// 配置文件本身发生变化，或者软链接指向了新的文件
+			currentConfigFile, _ := filepath.EvalSymlinks(configFile)
+			changed := filepath.Clean(event.Name) == configFile && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0
+			if !changed && currentConfigFile == realConfigFile {
+				continue
+			}
+			realConfigFile = currentConfigFile
+
+			l.Debug("Config file changed", zap.String("event", event.String()))
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(debounce)
+
+		case <-timer.C:
+			if err := sc.ReloadConfig(configFile); err != nil {
+				l.Error("Error reloading config after file change", zap.String("filePath", configFile), zap.Error(err))
+				continue
+			}
+			l.Info("Reloaded config file after file change", zap.String("filePath", configFile))
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			l.Error("Error watching config file", zap.String("filePath", configFile), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package conf_test
+
+import (
+	"context"
+	"github.com/yuanyp8/http_exporter/conf"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchReloadsConfig(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(content string) {
+		if err := os.WriteFile(configFile, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeConfig("modules:\n  http_get:\n    prober: http\n")
+	sc := &conf.SafeConfig{C: &conf.Config{}}
+	if err := sc.ReloadConfig(configFile); err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sc.Watch(ctx, configFile, 50*time.Millisecond)
+	// 等待watcher启动
+	time.Sleep(100 * time.Millisecond)
+
+	getModules := func() map[string]conf.Module {
+		sc.RLock()
+		defer sc.RUnlock()
+		return sc.C.Modules
+	}
+	waitFor := func(cond func() bool) bool {
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if cond() {
+				return true
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return false
+	}
+
+	writeConfig("modules:\n  http_post:\n    prober: http\n    http:\n      method: POST\n")
+	if !waitFor(func() bool { _, ok := getModules()["http_post"]; return ok }) {
+		t.Fatal("Config was not reloaded after file change")
+	}
+
+	// 非法的配置不会替换当前配置
+	writeConfig("modules:\n  http_bad:\n    prober: unknown\n")
+	time.Sleep(300 * time.Millisecond)
+	if _, ok := getModules()["http_post"]; !ok {
+		t.Fatal("Invalid config replaced the previous config")
+	}
+}
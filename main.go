@@ -1 +1,130 @@
 package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/version"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/prober"
+	"github.com/yuanyp8/http_exporter/utils"
+	"github.com/yuanyp8/http_exporter/web"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var (
+	configFile    = flag.String("config.file", "http_exporter.yml", "HTTP exporter configuration file.")
+	listenAddress = flag.String("web.listen-address", ":9115", "The address to listen on for HTTP requests.")
+	timeoutOffset = flag.Float64("timeout-offset", 0.5, "Offset to subtract from timeout in seconds.")
+	configCheck   = flag.Bool("config.check", false, "If true validate the config file and then exit.")
+	configWatch   = flag.Bool("config.watch", true, "Reload the config file automatically when it changes.")
+	watchDebounce = flag.Duration("config.watch-debounce", time.Second, "Wait this long after the last change of the config file before reloading it.")
+	historyLimit  = flag.Int("history.limit", 100, "The maximum amount of items to keep in the history, 0 disables the history and the probe logs.")
+	showVersion   = flag.Bool("version", false, "Print version information and exit.")
+
+	l = utils.Logger.Named("Main")
+)
+
+func init() {
+	prometheus.MustRegister(version.NewCollector("http_exporter"))
+}
+
+func main() {
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println(version.Print("http_exporter"))
+		os.Exit(0)
+	}
+
+	l.Info("Starting http_exporter", zap.String("version", version.Info()))
+	l.Info("Build context", zap.String("build_context", version.BuildContext()))
+
+	if err := conf.C().ReloadConfig(*configFile); err != nil {
+		l.Fatal("Error loading config", zap.String("filePath", *configFile), zap.Error(err))
+	}
+
+	if *configCheck {
+		l.Info("Config file is ok exiting...")
+		return
+	}
+
+	l.Info("Loaded config file", zap.String("filePath", *configFile))
+
+	// SIGHUP 和 /-/reload 都在同一个goroutine中触发重载
+	hup := make(chan os.Signal, 1)
+	reloadCh := make(chan chan error)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-hup:
+				if err := conf.C().ReloadConfig(*configFile); err != nil {
+					l.Error("Error reloading config", zap.Error(err))
+					continue
+				}
+				l.Info("Reloaded config file")
+			case rc := <-reloadCh:
+				if err := conf.C().ReloadConfig(*configFile); err != nil {
+					l.Error("Error reloading config", zap.Error(err))
+					rc <- err
+					continue
+				}
+				l.Info("Reloaded config file")
+				rc <- nil
+			}
+		}
+	}()
+
+	if *configWatch {
+		go func() {
+			if err := conf.C().Watch(context.Background(), *configFile, *watchDebounce); err != nil {
+				l.Error("Error watching config file", zap.String("filePath", *configFile), zap.Error(err))
+			}
+		}()
+	}
+
+	// 最近的探测结果，展示在首页
+	hist := prober.NewHistory(*historyLimit)
+
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/probe", func(w http.ResponseWriter, r *http.Request) {
+		sc := conf.C()
+		sc.RLock()
+		c := sc.C
+		sc.RUnlock()
+		prober.Handler(w, r, c, *timeoutOffset, hist)
+	})
+	http.HandleFunc("/", web.IndexHandler(conf.C(), hist))
+	http.HandleFunc("/logs", web.LogsHandler(hist))
+	http.HandleFunc("/config", web.ConfigHandler(conf.C()))
+	http.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			fmt.Fprintf(w, "This endpoint requires a POST request.\n")
+			return
+		}
+
+		rc := make(chan error)
+		reloadCh <- rc
+		if err := <-rc; err != nil {
+			http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+		}
+	})
+	http.HandleFunc("/-/healthy", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Healthy"))
+	})
+
+	l.Info("Listening on address", zap.String("address", *listenAddress))
+	if err := http.ListenAndServe(*listenAddress, nil); err != nil {
+		l.Fatal("Error starting HTTP server", zap.Error(err))
+	}
+}
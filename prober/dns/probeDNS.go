@@ -0,0 +1,269 @@
+package dns
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/utils"
+	"go.uber.org/zap"
+	"net"
+	"strings"
+	"time"
+)
+
+// logger 返回本次探测使用的logger
+func logger(ctx context.Context) *zap.Logger {
+	return utils.LoggerFromContext(ctx, "DNS")
+}
+
+// validRRs 根据 DNSRRValidator 校验一组资源记录
+func validRRs(ctx context.Context, rrs []dns.RR, v conf.DNSRRValidator, section string) bool {
+	l := logger(ctx).With(zap.String("section", section))
+
+	var records []string
+	for _, rr := range rrs {
+		records = append(records, rr.String())
+	}
+
+	for _, re := range v.FailIfMatchesRegexp {
+		for _, record := range records {
+			if re.MatchString(record) {
+				l.Error("At least one RR matched regexp", zap.String("regexp", re.String()), zap.String("rr", record))
+				return false
+			}
+		}
+	}
+	for _, re := range v.FailIfAllMatchRegexp {
+		matched := len(records) > 0
+		for _, record := range records {
+			if !re.MatchString(record) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			l.Error("All RRs matched regexp", zap.String("regexp", re.String()))
+			return false
+		}
+	}
+	for _, re := range v.FailIfNotMatchesRegexp {
+		for _, record := range records {
+			if !re.MatchString(record) {
+				l.Error("At least one RR did not match regexp", zap.String("regexp", re.String()), zap.String("rr", record))
+				return false
+			}
+		}
+	}
+	for _, re := range v.FailIfNoneMatchesRegexp {
+		matched := false
+		for _, record := range records {
+			if re.MatchString(record) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			l.Error("None of the RRs matched regexp", zap.String("regexp", re.String()))
+			return false
+		}
+	}
+	return true
+}
+
+// validRcode 未配置 valid_rcodes 时只接受 NOERROR
+func validRcode(rcode int, validRcodes []string) bool {
+	if len(validRcodes) == 0 {
+		return rcode == dns.RcodeSuccess
+	}
+	for _, valid := range validRcodes {
+		if dns.StringToRcode[strings.ToUpper(valid)] == rcode {
+			return true
+		}
+	}
+	return false
+}
+
+// ProbeDNS 向target指定的dns服务器发起一次查询并校验响应
+func ProbeDNS(ctx context.Context, target string, module conf.Module, registry *prometheus.Registry) bool {
+	var (
+		durationGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_dns_duration_seconds",
+			Help: "Duration of DNS request by phase",
+		}, []string{"phase"})
+
+		answerRRsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_dns_answer_rrs",
+			Help: "Returns number of entries in the answer resource record list",
+		})
+
+		authorityRRsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_dns_authority_rrs",
+			Help: "Returns number of entries in the authority resource record list",
+		})
+
+		additionalRRsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_dns_additional_rrs",
+			Help: "Returns number of entries in the additional resource record list",
+		})
+
+		querySucceededGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_dns_query_succeeded",
+			Help: "Displays whether or not the query was executed successfully",
+		})
+
+		rcodeGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_dns_rcode_info",
+			Help: "Contains the rcode of the DNS response",
+		}, []string{"rcode"})
+
+		serialGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_dns_serial",
+			Help: "Returns the serial number of the zone",
+		})
+	)
+
+	for _, lv := range []string{"resolve", "connect", "request"} {
+		durationGaugeVec.WithLabelValues(lv)
+	}
+	registry.MustRegister(durationGaugeVec, answerRRsGauge, authorityRRsGauge, additionalRRsGauge, querySucceededGauge, rcodeGaugeVec)
+
+	l := logger(ctx)
+	dnsConfig := *module.DNS
+
+	port := "53"
+	if dnsConfig.DNSOverTLS {
+		port = "853"
+	}
+	targetAddr, targetPort, err := net.SplitHostPort(target)
+	if err != nil {
+		// target 中没有端口，使用默认端口
+		targetAddr = strings.Trim(target, "[]")
+	} else {
+		port = targetPort
+	}
+
+	ip, lookupTime, err := conf.ChooseProtocol(ctx, dnsConfig.IPProtocol, dnsConfig.IPProtocolFallback, targetAddr, registry)
+	if err != nil {
+		l.Error("Error resolving address", zap.Error(err))
+		return false
+	}
+	durationGaugeVec.WithLabelValues("resolve").Add(lookupTime)
+	targetIP := net.JoinHostPort(ip.String(), port)
+
+	// e.g. udp4、tcp6、tcp4-tls
+	network := dnsConfig.TransportProtocol
+	if ip.IP.To4() == nil {
+		network += "6"
+	} else {
+		network += "4"
+	}
+	if dnsConfig.DNSOverTLS {
+		network += "-tls"
+	}
+
+	client := &dns.Client{Net: network, Dialer: &net.Dialer{}}
+	if dnsConfig.SourceIPAddress != "" {
+		srcIP := net.ParseIP(dnsConfig.SourceIPAddress)
+		if strings.HasPrefix(network, "udp") {
+			client.Dialer.LocalAddr = &net.UDPAddr{IP: srcIP}
+		} else {
+			client.Dialer.LocalAddr = &net.TCPAddr{IP: srcIP}
+		}
+	}
+	if dnsConfig.DNSOverTLS {
+		tlsConfig, err := pconfig.NewTLSConfig(&dnsConfig.TLSConfig)
+		if err != nil {
+			l.Error("Error creating TLS configuration", zap.Error(err))
+			utils.RecordFailure(ctx, utils.FailureConfig)
+			return false
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = targetAddr
+		}
+		client.TLSConfig = tlsConfig
+	}
+	// 整个查询不能超过探测的超时时间
+	if deadline, ok := ctx.Deadline(); ok {
+		client.Timeout = time.Until(deadline)
+	}
+
+	msg := &dns.Msg{}
+	msg.Id = dns.Id()
+	msg.RecursionDesired = dnsConfig.Recursion
+	msg.Question = []dns.Question{{
+		Name:   dns.Fqdn(dnsConfig.QueryName),
+		Qtype:  dns.StringToType[strings.ToUpper(dnsConfig.QueryType)],
+		Qclass: dns.StringToClass[strings.ToUpper(dnsConfig.QueryClass)],
+	}}
+
+	l.Info("Making DNS query",
+		zap.String("target", targetIP),
+		zap.String("network", network),
+		zap.String("query_name", msg.Question[0].Name),
+		zap.String("query_type", dnsConfig.QueryType))
+
+	connectStart := time.Now()
+	conn, err := client.DialContext(ctx, targetIP)
+	durationGaugeVec.WithLabelValues("connect").Add(time.Since(connectStart).Seconds())
+	if err != nil {
+		reason := utils.ClassifyError(err)
+		if reason == utils.FailureUnknown && dnsConfig.DNSOverTLS {
+			reason = utils.FailureTLSHandshake
+		}
+		l.Error("Error dialing DNS server", zap.String("reason", string(reason)), zap.Error(err))
+		utils.RecordFailure(ctx, reason)
+		return false
+	}
+	defer conn.Close()
+
+	requestStart := time.Now()
+	response, rtt, err := client.ExchangeWithConn(msg, conn)
+	durationGaugeVec.WithLabelValues("request").Add(time.Since(requestStart).Seconds())
+	if err != nil {
+		reason := utils.ClassifyError(err)
+		l.Error("Error while sending a DNS query", zap.String("reason", string(reason)), zap.Error(err))
+		utils.RecordFailure(ctx, reason)
+		return false
+	}
+	l.Info("Got response", zap.Duration("rtt", rtt), zap.Int("rcode", response.Rcode))
+	l.Debug("DNS response", zap.String("response", response.String()))
+	querySucceededGauge.Set(1)
+
+	answerRRsGauge.Set(float64(len(response.Answer)))
+	authorityRRsGauge.Set(float64(len(response.Ns)))
+	additionalRRsGauge.Set(float64(len(response.Extra)))
+	rcodeGaugeVec.WithLabelValues(dns.RcodeToString[response.Rcode]).Set(1)
+
+	// SOA 查询时记录zone的serial，便于发现主从不同步
+	for _, rr := range response.Answer {
+		if soa, ok := rr.(*dns.SOA); ok {
+			registry.MustRegister(serialGauge)
+			serialGauge.Set(float64(soa.Serial))
+			break
+		}
+	}
+
+	if !validRcode(response.Rcode, dnsConfig.ValidRcodes) {
+		l.Error("Rcode is not one of the valid rcodes", zap.String("rcode", dns.RcodeToString[response.Rcode]), zap.Strings("valid_rcodes", dnsConfig.ValidRcodes))
+		utils.RecordFailure(ctx, utils.FailureDNSRcode)
+		return false
+	}
+
+	for _, section := range []struct {
+		name      string
+		rrs       []dns.RR
+		validator conf.DNSRRValidator
+	}{
+		{"answer", response.Answer, dnsConfig.ValidateAnswer},
+		{"authority", response.Ns, dnsConfig.ValidateAuthority},
+		{"additional", response.Extra, dnsConfig.ValidateAdditional},
+	} {
+		if !validRRs(ctx, section.rrs, section.validator, section.name) {
+			utils.RecordFailure(ctx, utils.FailureRegexRR)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,205 @@
+package dns
+
+import (
+	"crypto/tls"
+	"github.com/miekg/dns"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/prober/probetest"
+	"github.com/yuanyp8/http_exporter/utils"
+	"net"
+	"testing"
+	"time"
+)
+
+// handleRequest 模拟一个权威服务器：example.com 有A和SOA记录，其它域名返回 NXDOMAIN
+func handleRequest(w dns.ResponseWriter, r *dns.Msg) {
+	m := &dns.Msg{}
+	m.SetReply(r)
+	m.Authoritative = true
+	q := r.Question[0]
+	if q.Name != "example.com." {
+		m.SetRcode(r, dns.RcodeNameError)
+		w.WriteMsg(m)
+		return
+	}
+	switch q.Qtype {
+	case dns.TypeA:
+		a1, _ := dns.NewRR("example.com. 300 IN A 192.0.2.1")
+		a2, _ := dns.NewRR("example.com. 300 IN A 192.0.2.2")
+		m.Answer = []dns.RR{a1, a2}
+	case dns.TypeSOA:
+		soa, _ := dns.NewRR("example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 2022110101 7200 3600 1209600 300")
+		m.Answer = []dns.RR{soa}
+	}
+	ns, _ := dns.NewRR("example.com. 300 IN NS ns1.example.com.")
+	glue, _ := dns.NewRR("ns1.example.com. 300 IN A 192.0.2.53")
+	m.Ns = []dns.RR{ns}
+	m.Extra = []dns.RR{glue}
+	w.WriteMsg(m)
+}
+
+// startDNSServer 在本地启动dns服务器，network 为 udp、tcp 或 tcp-tls
+func startDNSServer(t *testing.T, network string) string {
+	t.Helper()
+	server := &dns.Server{Handler: dns.HandlerFunc(handleRequest)}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+
+	switch network {
+	case "udp":
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.PacketConn = pc
+	case "tcp":
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.Listener = ln
+	case "tcp-tls":
+		ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{probetest.Certificate()}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.Listener = ln
+		server.Net = "tcp-tls"
+	}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+
+	if server.PacketConn != nil {
+		return server.PacketConn.LocalAddr().String()
+	}
+	return server.Listener.Addr().String()
+}
+
+func newTestModule(dnsProbe *conf.DNSProbe) conf.Module {
+	return conf.Module{
+		Prober:  "dns",
+		Timeout: time.Second,
+		DNS:     dnsProbe,
+	}
+}
+
+func TestDNSProtocols(t *testing.T) {
+	for _, network := range []string{"udp", "tcp"} {
+		t.Run(network, func(t *testing.T) {
+			dnsProbe := conf.NewDefaultDNSProbe()
+			dnsProbe.TransportProtocol = network
+			dnsProbe.QueryName = "example.com"
+
+			result, reason, registry := probetest.Probe(t, ProbeDNS, startDNSServer(t, network), newTestModule(dnsProbe))
+			probetest.CheckResult(t, result, reason, true, "")
+			probetest.CheckResults(t, registry, map[string]float64{
+				"probe_dns_query_succeeded": 1,
+				"probe_dns_answer_rrs":      2,
+				"probe_dns_authority_rrs":   1,
+				"probe_dns_additional_rrs":  1,
+			})
+		})
+	}
+}
+
+func TestDNSOverTLS(t *testing.T) {
+	target := startDNSServer(t, "tcp-tls")
+
+	tests := []struct {
+		name               string
+		insecureSkipVerify bool
+		expectedResult     bool
+		expectedReason     utils.FailureReason
+	}{
+		// 自签名证书校验失败
+		{"self-signed certificate", false, false, utils.FailureCertInvalid},
+		{"insecure_skip_verify", true, true, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dnsProbe := conf.NewDefaultDNSProbe()
+			dnsProbe.TransportProtocol = "tcp"
+			dnsProbe.DNSOverTLS = true
+			dnsProbe.QueryName = "example.com"
+			dnsProbe.TLSConfig.InsecureSkipVerify = test.insecureSkipVerify
+
+			result, reason, _ := probetest.Probe(t, ProbeDNS, target, newTestModule(dnsProbe))
+			probetest.CheckResult(t, result, reason, test.expectedResult, test.expectedReason)
+		})
+	}
+}
+
+func TestDNSRcode(t *testing.T) {
+	target := startDNSServer(t, "udp")
+
+	tests := []struct {
+		name           string
+		validRcodes    []string
+		expectedResult bool
+		expectedReason utils.FailureReason
+	}{
+		{"default", nil, false, utils.FailureDNSRcode},
+		{"NOERROR", []string{"NOERROR"}, false, utils.FailureDNSRcode},
+		{"NXDOMAIN", []string{"NXDOMAIN"}, true, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dnsProbe := conf.NewDefaultDNSProbe()
+			dnsProbe.QueryName = "nonexistent.example.com"
+			dnsProbe.ValidRcodes = test.validRcodes
+
+			result, reason, _ := probetest.Probe(t, ProbeDNS, target, newTestModule(dnsProbe))
+			probetest.CheckResult(t, result, reason, test.expectedResult, test.expectedReason)
+		})
+	}
+}
+
+func TestDNSSerial(t *testing.T) {
+	dnsProbe := conf.NewDefaultDNSProbe()
+	dnsProbe.QueryName = "example.com"
+	dnsProbe.QueryType = "SOA"
+
+	result, reason, registry := probetest.Probe(t, ProbeDNS, startDNSServer(t, "udp"), newTestModule(dnsProbe))
+	probetest.CheckResult(t, result, reason, true, "")
+	probetest.CheckResults(t, registry, map[string]float64{"probe_dns_serial": 2022110101})
+}
+
+func TestDNSRRValidation(t *testing.T) {
+	target := startDNSServer(t, "udp")
+	re := func(expr string) []conf.Regexp {
+		return []conf.Regexp{*conf.MustNewRegexp(expr)}
+	}
+
+	tests := []struct {
+		name           string
+		configure      func(dnsProbe *conf.DNSProbe)
+		expectedResult bool
+	}{
+		{"fail_if_matches", func(d *conf.DNSProbe) { d.ValidateAnswer.FailIfMatchesRegexp = re(`192\.0\.2\.2$`) }, false},
+		{"fail_if_matches no match", func(d *conf.DNSProbe) { d.ValidateAnswer.FailIfMatchesRegexp = re(`10\.0\.0\.1$`) }, true},
+		{"fail_if_all_match", func(d *conf.DNSProbe) { d.ValidateAnswer.FailIfAllMatchRegexp = re(`\tA\t192\.0\.2\.`) }, false},
+		{"fail_if_all_match partial", func(d *conf.DNSProbe) { d.ValidateAnswer.FailIfAllMatchRegexp = re(`192\.0\.2\.1$`) }, true},
+		{"fail_if_not_matches", func(d *conf.DNSProbe) { d.ValidateAnswer.FailIfNotMatchesRegexp = re(`192\.0\.2\.1$`) }, false},
+		{"fail_if_none_matches", func(d *conf.DNSProbe) { d.ValidateAnswer.FailIfNoneMatchesRegexp = re(`192\.0\.2\.1$`) }, true},
+		{"authority", func(d *conf.DNSProbe) { d.ValidateAuthority.FailIfNoneMatchesRegexp = re(`NS\tns2\.`) }, false},
+		{"additional", func(d *conf.DNSProbe) { d.ValidateAdditional.FailIfNotMatchesRegexp = re(`^ns1\.example\.com\.`) }, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dnsProbe := conf.NewDefaultDNSProbe()
+			dnsProbe.QueryName = "example.com"
+			test.configure(dnsProbe)
+
+			var expectedReason utils.FailureReason
+			if !test.expectedResult {
+				expectedReason = utils.FailureRegexRR
+			}
+			result, reason, _ := probetest.Probe(t, ProbeDNS, target, newTestModule(dnsProbe))
+			probetest.CheckResult(t, result, reason, test.expectedResult, expectedReason)
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/utils"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+	"net"
+	"sync"
+	"time"
+)
+
+// logger 返回本次探测使用的logger
+func logger(ctx context.Context) *zap.Logger {
+	return utils.LoggerFromContext(ctx, "GRPC")
+}
+
+// grpcTrace 记录连接和请求各阶段的时间点，与http探测的阶段保持一致
+type grpcTrace struct {
+	mu            sync.Mutex
+	connectStart  time.Time
+	connectDone   time.Time
+	tlsStart      time.Time
+	tlsDone       time.Time
+	requestSent   time.Time
+	responseStart time.Time
+	end           time.Time
+	tlsState      *tls.ConnectionState
+}
+
+// traceCredentials 包装TLS credentials，记录握手耗时和握手后的连接状态
+type traceCredentials struct {
+	credentials.TransportCredentials
+	ctx   context.Context
+	trace *grpcTrace
+}
+
+func (c *traceCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	start := time.Now()
+	conn, authInfo, err := c.TransportCredentials.ClientHandshake(ctx, authority, rawConn)
+	c.trace.mu.Lock()
+	c.trace.tlsStart, c.trace.tlsDone = start, time.Now()
+	if info, ok := authInfo.(credentials.TLSInfo); ok {
+		c.trace.tlsState = &info.State
+	}
+	c.trace.mu.Unlock()
+	if err != nil {
+		reason := utils.ClassifyError(err)
+		if reason == utils.FailureUnknown {
+			reason = utils.FailureTLSHandshake
+		}
+		logger(c.ctx).Error("TLS handshake failed", zap.String("reason", string(reason)), zap.Error(err))
+		utils.RecordFailure(c.ctx, reason)
+	}
+	return conn, authInfo, err
+}
+
+func (c *traceCredentials) Clone() credentials.TransportCredentials {
+	return &traceCredentials{TransportCredentials: c.TransportCredentials.Clone(), ctx: c.ctx, trace: c.trace}
+}
+
+// traceStatsHandler 通过grpc的stats回调记录请求发出、收到响应头以及请求结束的时间
+type traceStatsHandler struct {
+	trace *grpcTrace
+}
+
+func (h *traceStatsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (h *traceStatsHandler) HandleRPC(_ context.Context, s stats.RPCStats) {
+	h.trace.mu.Lock()
+	defer h.trace.mu.Unlock()
+	switch s.(type) {
+	case *stats.OutPayload:
+		h.trace.requestSent = time.Now()
+	case *stats.InHeader:
+		h.trace.responseStart = time.Now()
+	case *stats.End:
+		h.trace.end = time.Now()
+	}
+}
+
+func (h *traceStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *traceStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+// ProbeGRPC 调用 grpc.health.v1.Health/Check，服务状态为 SERVING 时探测成功
+func ProbeGRPC(ctx context.Context, target string, module conf.Module, registry *prometheus.Registry) bool {
+	var (
+		durationGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_grpc_duration_seconds",
+			Help: "Duration of gRPC request by phase",
+		}, []string{"phase"})
+
+		isSSLGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_grpc_ssl",
+			Help: "Indicates if SSL was used for the connection",
+		})
+
+		statusCodeGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_grpc_status_code",
+			Help: "Response gRPC status code",
+		})
+
+		healthCheckResponseGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_grpc_healthcheck_response",
+			Help: "Response HealthCheck response",
+		}, []string{"serving_status"})
+	)
+
+	for _, lv := range []string{"resolve", "connect", "tls", "processing", "transfer"} {
+		durationGaugeVec.WithLabelValues(lv)
+	}
+	registry.MustRegister(durationGaugeVec, isSSLGauge, statusCodeGauge, healthCheckResponseGaugeVec)
+
+	l := logger(ctx)
+	grpcConfig := *module.GRPC
+
+	targetAddress, port, err := net.SplitHostPort(target)
+	if err != nil {
+		l.Error("Error splitting target address and port", zap.Error(err))
+		utils.RecordFailure(ctx, utils.FailureInvalidTarget)
+		return false
+	}
+
+	ip, lookupTime, err := conf.ChooseProtocol(ctx, grpcConfig.IPProtocol, grpcConfig.IPProtocolFallback, targetAddress, registry)
+	if err != nil {
+		l.Error("Error resolving address", zap.Error(err))
+		return false
+	}
+	durationGaugeVec.WithLabelValues("resolve").Add(lookupTime)
+
+	dialProtocol := "tcp6"
+	if ip.IP.To4() != nil {
+		dialProtocol = "tcp4"
+	}
+
+	trace := &grpcTrace{}
+	dialer := &net.Dialer{}
+	opts := []grpc.DialOption{
+		grpc.WithStatsHandler(&traceStatsHandler{trace: trace}),
+		grpc.WithContextDialer(func(dialCtx context.Context, addr string) (net.Conn, error) {
+			start := time.Now()
+			conn, err := dialer.DialContext(dialCtx, dialProtocol, addr)
+			trace.mu.Lock()
+			trace.connectStart, trace.connectDone = start, time.Now()
+			trace.mu.Unlock()
+			if err != nil {
+				reason := utils.ClassifyError(err)
+				l.Error("Error dialing gRPC server", zap.String("reason", string(reason)), zap.Error(err))
+				utils.RecordFailure(ctx, reason)
+			}
+			return conn, err
+		}),
+	}
+
+	if grpcConfig.TLS {
+		tlsConfig, err := pconfig.NewTLSConfig(&grpcConfig.TLSConfig)
+		if err != nil {
+			l.Error("Error creating TLS configuration", zap.Error(err))
+			utils.RecordFailure(ctx, utils.FailureConfig)
+			return false
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = targetAddress
+		}
+		opts = append(opts, grpc.WithTransportCredentials(&traceCredentials{
+			TransportCredentials: credentials.NewTLS(tlsConfig),
+			ctx:                  ctx,
+			trace:                trace,
+		}))
+	} else {
+		// TLS时 :authority 取自server name，明文时使用target而不是解析后的ip
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithAuthority(target))
+	}
+
+	dialTarget := net.JoinHostPort(ip.String(), port)
+	l.Info("Dialing gRPC server", zap.String("address", dialTarget), zap.Bool("tls", grpcConfig.TLS))
+	conn, err := grpc.DialContext(ctx, dialTarget, opts...)
+	if err != nil {
+		l.Error("Error creating gRPC client", zap.Error(err))
+		utils.RecordFailure(ctx, utils.FailureConfig)
+		return false
+	}
+	defer conn.Close()
+
+	l.Info("Checking health", zap.String("service", grpcConfig.Service))
+	client := grpc_health_v1.NewHealthClient(conn)
+	resp, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: grpcConfig.Service})
+
+	trace.mu.Lock()
+	if !trace.connectDone.IsZero() {
+		durationGaugeVec.WithLabelValues("connect").Add(trace.connectDone.Sub(trace.connectStart).Seconds())
+	}
+	if !trace.tlsDone.IsZero() {
+		durationGaugeVec.WithLabelValues("tls").Add(trace.tlsDone.Sub(trace.tlsStart).Seconds())
+	}
+	if !trace.responseStart.IsZero() {
+		durationGaugeVec.WithLabelValues("processing").Add(trace.responseStart.Sub(trace.requestSent).Seconds())
+		durationGaugeVec.WithLabelValues("transfer").Add(trace.end.Sub(trace.responseStart).Seconds())
+	}
+	tlsState := trace.tlsState
+	trace.mu.Unlock()
+
+	if tlsState != nil {
+		isSSLGauge.Set(1)
+		utils.RegisterTLSStateMetrics(registry, tlsState)
+	}
+
+	code := status.Code(err)
+	statusCodeGauge.Set(float64(code))
+	if err != nil {
+		// 连接和握手阶段的失败已经在dialer和credentials中记录
+		reason := utils.FailureGRPCStatus
+		if code == codes.DeadlineExceeded {
+			reason = utils.FailureTimeout
+		}
+		l.Error("Error checking health", zap.String("code", code.String()), zap.String("reason", string(reason)), zap.Error(err))
+		utils.RecordFailure(ctx, reason)
+		return false
+	}
+
+	for value, name := range grpc_health_v1.HealthCheckResponse_ServingStatus_name {
+		healthCheckResponseGaugeVec.WithLabelValues(name)
+		if grpc_health_v1.HealthCheckResponse_ServingStatus(value) == resp.GetStatus() {
+			healthCheckResponseGaugeVec.WithLabelValues(name).Set(1)
+		}
+	}
+
+	l.Info("Got health check response", zap.String("serving_status", resp.GetStatus().String()))
+	if resp.GetStatus() != grpc_health_v1.HealthCheckResponse_SERVING {
+		l.Error("Service is not serving", zap.String("serving_status", resp.GetStatus().String()))
+		utils.RecordFailure(ctx, utils.FailureServingStatus)
+		return false
+	}
+	return true
+}
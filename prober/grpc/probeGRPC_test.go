@@ -0,0 +1,119 @@
+package grpc
+
+import (
+	"crypto/tls"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/prober/probetest"
+	"github.com/yuanyp8/http_exporter/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestModule(grpcProbe *conf.GRPCProbe) conf.Module {
+	return conf.Module{
+		Prober:  "grpc",
+		Timeout: time.Second,
+		GRPC:    grpcProbe,
+	}
+}
+
+// startGRPCServer 启动带健康检查服务的grpc服务器，service_a 为 SERVING，service_b 为 NOT_SERVING
+func startGRPCServer(t *testing.T, opts ...grpc.ServerOption) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("service_a", grpc_health_v1.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus("service_b", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+
+	server := grpc.NewServer(opts...)
+	grpc_health_v1.RegisterHealthServer(server, healthServer)
+	go server.Serve(ln)
+	t.Cleanup(server.Stop)
+	return ln.Addr().String()
+}
+
+func TestGRPCHealthCheck(t *testing.T) {
+	target := startGRPCServer(t)
+
+	tests := []struct {
+		name           string
+		service        string
+		expectedResult bool
+		expectedReason utils.FailureReason
+		expected       map[string]float64
+	}{
+		{"server", "", true, "", map[string]float64{"probe_grpc_status_code": 0, "probe_grpc_healthcheck_response{SERVING}": 1}},
+		{"serving", "service_a", true, "", map[string]float64{"probe_grpc_status_code": 0, "probe_grpc_healthcheck_response{SERVING}": 1}},
+		{"not serving", "service_b", false, utils.FailureServingStatus, map[string]float64{"probe_grpc_status_code": 0, "probe_grpc_healthcheck_response{NOT_SERVING}": 1}},
+		// 未注册的服务返回 NOT_FOUND
+		{"unknown service", "service_c", false, utils.FailureGRPCStatus, map[string]float64{"probe_grpc_status_code": 5}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			grpcProbe := conf.NewDefaultGRPCProbe()
+			grpcProbe.Service = test.service
+
+			result, reason, registry := probetest.Probe(t, ProbeGRPC, target, newTestModule(grpcProbe))
+			probetest.CheckResult(t, result, reason, test.expectedResult, test.expectedReason)
+			probetest.CheckResults(t, registry, test.expected)
+			results := probetest.Gather(t, registry)
+			if result && (results["probe_grpc_duration_seconds{connect}"] <= 0 || results["probe_grpc_duration_seconds{processing}"] <= 0) {
+				t.Errorf("Phase durations not recorded: %v", results)
+			}
+		})
+	}
+}
+
+func TestGRPCConnectionRefused(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := ln.Addr().String()
+	ln.Close()
+
+	result, reason, _ := probetest.Probe(t, ProbeGRPC, target, newTestModule(conf.NewDefaultGRPCProbe()))
+	probetest.CheckResult(t, result, reason, false, utils.FailureConnectRefused)
+}
+
+func TestGRPCTLS(t *testing.T) {
+	target := startGRPCServer(t, grpc.Creds(credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{probetest.Certificate()}})))
+
+	tests := []struct {
+		name               string
+		insecureSkipVerify bool
+		expectedResult     bool
+		expectedReason     utils.FailureReason
+	}{
+		// 自签名证书校验失败
+		{"self-signed certificate", false, false, utils.FailureCertInvalid},
+		{"insecure_skip_verify", true, true, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			grpcProbe := conf.NewDefaultGRPCProbe()
+			grpcProbe.TLS = true
+			grpcProbe.TLSConfig.InsecureSkipVerify = test.insecureSkipVerify
+
+			result, reason, registry := probetest.Probe(t, ProbeGRPC, target, newTestModule(grpcProbe))
+			probetest.CheckResult(t, result, reason, test.expectedResult, test.expectedReason)
+			if !test.expectedResult {
+				return
+			}
+			probetest.CheckResults(t, registry, map[string]float64{"probe_grpc_ssl": 1, "probe_tls_version_info{TLS 1.3}": 1})
+			if duration := probetest.Gather(t, registry)["probe_grpc_duration_seconds{tls}"]; duration <= 0 {
+				t.Errorf("TLS duration not recorded")
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package prober
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+	"github.com/yuanyp8/http_exporter/conf"
+	dnsprober "github.com/yuanyp8/http_exporter/prober/dns"
+	grpcprober "github.com/yuanyp8/http_exporter/prober/grpc"
+	httpprober "github.com/yuanyp8/http_exporter/prober/http"
+	icmpprober "github.com/yuanyp8/http_exporter/prober/icmp"
+	tcpprober "github.com/yuanyp8/http_exporter/prober/tcp"
+	tlsprober "github.com/yuanyp8/http_exporter/prober/tls"
+	websocketprober "github.com/yuanyp8/http_exporter/prober/websocket"
+	"github.com/yuanyp8/http_exporter/utils"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ProbeFn 所有prober需要实现的探测函数签名
+type ProbeFn func(ctx context.Context, target string, module conf.Module, registry *prometheus.Registry) bool
+
+// Probers 根据 Module.Prober 的名称选择对应的探测函数
+var Probers = map[string]ProbeFn{
+	"http":      httpprober.ProbeHTTP,
+	"tcp":       tcpprober.ProbeTCP,
+	"dns":       dnsprober.ProbeDNS,
+	"icmp":      icmpprober.ProbeICMP,
+	"grpc":      grpcprober.ProbeGRPC,
+	"websocket": websocketprober.ProbeWebSocket,
+	"tls":       tlsprober.ProbeTLS,
+}
+
+// Handler 处理 /probe?target=...&module=... 请求
+// 每次请求都会新建一个registry，只返回本次探测产生的metrics；hist 启用时保存本次探测的结果
+func Handler(w http.ResponseWriter, r *http.Request, c *conf.Config, timeoutOffset float64, hist *History) {
+	params := r.URL.Query()
+
+	moduleName := params.Get("module")
+	if moduleName == "" {
+		moduleName = "http_2xx"
+	}
+	module, ok := c.Modules[moduleName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown module %q", moduleName), http.StatusBadRequest)
+		return
+	}
+
+	target := params.Get("target")
+	if target == "" {
+		http.Error(w, "Target parameter is missing", http.StatusBadRequest)
+		return
+	}
+
+	prober, ok := Probers[module.Prober]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown prober %q", module.Prober), http.StatusBadRequest)
+		return
+	}
+
+	timeoutSeconds, err := getTimeout(r, module, timeoutOffset)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse timeout from Prometheus header: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	// 探测的超时时间由 Module.Timeout 和 Prometheus 的 scrape timeout 共同决定
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeoutSeconds*float64(time.Second)))
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	// prober 在失败时通过ctx记录失败原因
+	ctx, failureReason := utils.NewFailureContext(ctx)
+
+	// debug或需要保存探测结果时使用单独的logger记录本次探测的所有日志
+	debug := params.Get("debug") == "true"
+	logs := &bytes.Buffer{}
+	if debug || hist.Enabled() {
+		ctx = utils.WithLogger(ctx, utils.NewCaptureLogger(logs))
+	}
+	l := utils.LoggerFromContext(ctx, "Prober")
+
+	probeSuccessGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_success",
+		Help: "Displays whether or not the probe was a success",
+	})
+	probeDurationGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_duration_seconds",
+		Help: "Returns how long the probe took to complete in seconds",
+	})
+	probeFailureReasonGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_failure_reason",
+		Help: "Indicates why the probe failed, only exported when the probe failed",
+	}, []string{"reason"})
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(probeSuccessGauge, probeDurationGauge, probeFailureReasonGauge)
+
+	l.Info("Beginning probe", zap.String("module", moduleName), zap.String("target", target), zap.Float64("timeout_seconds", timeoutSeconds))
+
+	start := time.Now()
+	success := prober(ctx, target, module, registry)
+	duration := time.Since(start).Seconds()
+	probeDurationGauge.Set(duration)
+	var reason utils.FailureReason
+	if success {
+		probeSuccessGauge.Set(1)
+		l.Info("Probe succeeded", zap.String("module", moduleName), zap.String("target", target), zap.Float64("duration_seconds", duration))
+	} else {
+		if reason = failureReason(); reason == "" {
+			reason = utils.FailureUnknown
+		}
+		probeFailureReasonGauge.WithLabelValues(string(reason)).Set(1)
+		l.Error("Probe failed", zap.String("module", moduleName), zap.String("target", target), zap.String("reason", string(reason)), zap.Float64("duration_seconds", duration))
+	}
+
+	// 历史结果只保存原始日志和registry，请求 /logs?id= 时才生成debug输出
+	if hist.Enabled() {
+		hist.Add(&Result{
+			Time:          start,
+			Target:        target,
+			Module:        moduleName,
+			Success:       success,
+			Duration:      duration,
+			FailureReason: reason,
+			Logs:          logs.Bytes(),
+			Registry:      registry,
+			ModuleConfig:  module,
+		})
+	}
+
+	if debug {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(debugOutput(module, logs.Bytes(), registry)))
+		return
+	}
+
+	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	h.ServeHTTP(w, r)
+}
+
+// debugOutput ?debug=true 时返回本次探测的日志、metrics和模块配置
+func debugOutput(module conf.Module, logs []byte, registry prometheus.Gatherer) string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintln(buf, "Logs for the probe:")
+	buf.Write(logs)
+
+	fmt.Fprintln(buf, "\n\nMetrics that would have been returned:")
+	if registry != nil {
+		mfs, err := registry.Gather()
+		if err != nil {
+			fmt.Fprintf(buf, "Error gathering metrics: %s\n", err)
+		}
+		for _, mf := range mfs {
+			expfmt.MetricFamilyToText(buf, mf)
+		}
+	}
+
+	fmt.Fprintln(buf, "\n\nModule configuration:")
+	c, err := yaml.Marshal(module)
+	if err != nil {
+		fmt.Fprintf(buf, "Error marshalling config: %s\n", err)
+	}
+	buf.Write(c)
+	return buf.String()
+}
+
+// getTimeout 计算本次探测的超时时间（秒）
+// 优先使用 Prometheus 传递的 scrape timeout 减去 offset，并且不超过模块配置的timeout
+func getTimeout(r *http.Request, module conf.Module, offset float64) (timeoutSeconds float64, err error) {
+	// 默认使用 Prometheus 默认的 scrape timeout
+	timeoutSeconds = 10
+	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
+		timeoutSeconds, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if timeoutSeconds > offset {
+		timeoutSeconds -= offset
+	}
+
+	if module.Timeout.Seconds() > 0 {
+		timeoutSeconds = math.Min(timeoutSeconds, module.Timeout.Seconds())
+	}
+	return timeoutSeconds, nil
+}
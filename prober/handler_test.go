@@ -0,0 +1,199 @@
+package prober
+
+import (
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebugOutput(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "debug")
+	}))
+	defer ts.Close()
+
+	httpProbe := conf.NewDefaultHTTPProbe()
+	httpProbe.Headers = conf.Headers{"Authorization": "Bearer s3cret"}
+	c := &conf.Config{Modules: map[string]conf.Module{
+		"http_2xx": {Prober: "http", HTTP: httpProbe},
+	}}
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"debug=true", []string{
+			"Logs for the probe:",
+			"Beginning probe",
+			"Response headers for roundtrip",
+			"X-Test",
+			"Metrics that would have been returned:",
+			"probe_success 1",
+			"Module configuration:",
+			"prober: http",
+			"Authorization: <secret>",
+		}},
+		{"debug=false", []string{"probe_success 1"}},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/probe?target="+ts.URL+"&"+test.query, nil)
+		rr := httptest.NewRecorder()
+		Handler(rr, req, c, 0.5, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Unexpected status code %d", rr.Code)
+		}
+		body := rr.Body.String()
+		for _, s := range test.expected {
+			if !strings.Contains(body, s) {
+				t.Errorf("%s: expected output to contain %q, got:\n%s", test.query, s, body)
+			}
+		}
+		// 日志和模块配置中都不能出现认证信息
+		if strings.Contains(body, "s3cret") {
+			t.Errorf("%s: expected Authorization header to be redacted, got:\n%s", test.query, body)
+		}
+		if test.query == "debug=false" && strings.Contains(body, "Logs for the probe:") {
+			t.Errorf("Unexpected debug output without debug=true")
+		}
+	}
+}
+
+func TestHandlerHistory(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	c := &conf.Config{Modules: map[string]conf.Module{
+		"http_2xx": {Prober: "http", HTTP: conf.NewDefaultHTTPProbe()},
+	}}
+	hist := NewHistory(10)
+
+	req := httptest.NewRequest(http.MethodGet, "/probe?target="+ts.URL, nil)
+	Handler(httptest.NewRecorder(), req, c, 0.5, hist)
+
+	results := hist.List()
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 result, got %d", len(results))
+	}
+	r := results[0]
+	if r.Success || r.Module != "http_2xx" || r.Target != ts.URL || r.FailureReason != utils.FailureStatusCode {
+		t.Errorf("Unexpected result %+v", r)
+	}
+	if output := r.DebugOutput(); !strings.Contains(output, "Invalid HTTP response status code") || !strings.Contains(output, "probe_success 0") {
+		t.Errorf("Expected debug output to contain the probe logs and metrics, got:\n%s", output)
+	}
+
+	// history.limit 为0时不保存结果
+	disabled := NewHistory(0)
+	if disabled.Enabled() {
+		t.Fatalf("Expected history with limit 0 to be disabled")
+	}
+	Handler(httptest.NewRecorder(), req, c, 0.5, disabled)
+	if results := disabled.List(); len(results) != 0 {
+		t.Errorf("Expected no results in a disabled history, got %d", len(results))
+	}
+}
+
+func TestHandlerBadRequests(t *testing.T) {
+	c := &conf.Config{Modules: map[string]conf.Module{
+		"http_2xx": {Prober: "http", HTTP: conf.NewDefaultHTTPProbe()},
+		"unknown":  {Prober: "unknown"},
+	}}
+
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{"unknown module", "target=127.0.0.1&module=foo", `Unknown module "foo"`},
+		{"missing target", "module=http_2xx", "Target parameter is missing"},
+		{"unknown prober", "target=127.0.0.1&module=unknown", `Unknown prober "unknown"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			Handler(rr, httptest.NewRequest(http.MethodGet, "/probe?"+test.query, nil), c, 0.5, nil)
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("Expected status code 400, got %d", rr.Code)
+			}
+			if !strings.Contains(rr.Body.String(), test.expected) {
+				t.Errorf("Expected body to contain %q, got %q", test.expected, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandlerMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	c := &conf.Config{Modules: map[string]conf.Module{
+		"http_2xx": {Prober: "http", HTTP: conf.NewDefaultHTTPProbe()},
+	}}
+
+	tests := []struct {
+		name     string
+		target   string
+		expected []string
+	}{
+		{"success", ts.URL, []string{"probe_success 1", "probe_duration_seconds "}},
+		{"failure", "http://127.0.0.1:0", []string{"probe_success 0", "probe_duration_seconds ", `probe_failure_reason{reason="`}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			Handler(rr, httptest.NewRequest(http.MethodGet, "/probe?target="+test.target, nil), c, 0.5, nil)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Unexpected status code %d", rr.Code)
+			}
+			body := rr.Body.String()
+			for _, s := range test.expected {
+				if !strings.Contains(body, s) {
+					t.Errorf("Expected body to contain %q, got:\n%s", s, body)
+				}
+			}
+		})
+	}
+}
+
+func TestGetTimeout(t *testing.T) {
+	tests := []struct {
+		name          string
+		scrapeTimeout string
+		moduleTimeout time.Duration
+		offset        float64
+		expected      float64
+		expectedError bool
+	}{
+		{"default", "", 0, 0.5, 9.5, false},
+		{"scrape timeout with offset", "5", 0, 0.5, 4.5, false},
+		{"offset larger than scrape timeout", "0.3", 0, 0.5, 0.3, false},
+		{"module timeout", "5", 2 * time.Second, 0.5, 2, false},
+		{"scrape timeout shorter than module timeout", "2", 5 * time.Second, 0.5, 1.5, false},
+		{"invalid header", "five", 0, 0.5, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/probe", nil)
+			if test.scrapeTimeout != "" {
+				req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", test.scrapeTimeout)
+			}
+			timeout, err := getTimeout(req, conf.Module{Timeout: test.moduleTimeout}, test.offset)
+			if (err != nil) != test.expectedError {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if timeout != test.expected {
+				t.Errorf("Expected timeout %v, got %v", test.expected, timeout)
+			}
+		})
+	}
+}
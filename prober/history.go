@@ -0,0 +1,86 @@
+package prober
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/utils"
+	"sync"
+	"time"
+)
+
+// Result 一次探测的结果，展示在web页面上
+type Result struct {
+	ID            int64
+	Time          time.Time
+	Target        string
+	Module        string
+	Success       bool
+	Duration      float64
+	FailureReason utils.FailureReason
+	Logs          []byte              // 本次探测的原始日志
+	Registry      prometheus.Gatherer // 本次探测产生的metrics
+	ModuleConfig  conf.Module
+}
+
+// DebugOutput 生成与 ?debug=true 相同的debug输出，只在查看日志时调用
+func (r *Result) DebugOutput() string {
+	return debugOutput(r.ModuleConfig, r.Logs, r.Registry)
+}
+
+// History 保存最近的探测结果，超过上限时覆盖最早的结果
+type History struct {
+	mu      sync.Mutex
+	results []*Result
+	nextID  int64
+	max     int
+}
+
+// NewHistory 最多保存max条探测结果，max<=0时不保存
+func NewHistory(max int) *History {
+	return &History{max: max}
+}
+
+// Enabled 是否需要保存探测结果，未启用时探测不会记录日志
+func (h *History) Enabled() bool {
+	return h != nil && h.max > 0
+}
+
+// Add 添加一条探测结果并为它分配ID
+func (h *History) Add(r *Result) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.max <= 0 {
+		return
+	}
+
+	r.ID = h.nextID
+	h.nextID++
+	if len(h.results) < h.max {
+		h.results = append(h.results, r)
+		return
+	}
+	h.results[r.ID%int64(h.max)] = r
+}
+
+// List 返回所有保存的探测结果，最新的在前
+func (h *History) List() []*Result {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	results := make([]*Result, 0, len(h.results))
+	for id := h.nextID - 1; id >= 0 && id >= h.nextID-int64(len(h.results)); id-- {
+		results = append(results, h.results[id%int64(h.max)])
+	}
+	return results
+}
+
+// Get 根据ID返回探测结果，已经被覆盖的结果返回nil
+func (h *History) Get(id int64) *Result {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if id < 0 || id >= h.nextID || id < h.nextID-int64(len(h.results)) {
+		return nil
+	}
+	return h.results[id%int64(h.max)]
+}
@@ -0,0 +1,33 @@
+package prober
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	hist := NewHistory(3)
+	for i := 0; i < 5; i++ {
+		hist.Add(&Result{Target: fmt.Sprintf("target-%d", i)})
+	}
+
+	results := hist.List()
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(results))
+	}
+	for i, expected := range []string{"target-4", "target-3", "target-2"} {
+		if results[i].Target != expected {
+			t.Errorf("Expected result %d to be %s, got %s", i, expected, results[i].Target)
+		}
+	}
+
+	if r := hist.Get(1); r != nil {
+		t.Errorf("Expected result 1 to be overwritten, got %s", r.Target)
+	}
+	if r := hist.Get(2); r == nil || r.Target != "target-2" {
+		t.Errorf("Expected result 2 to be target-2, got %v", r)
+	}
+	if r := hist.Get(5); r != nil {
+		t.Errorf("Expected result 5 not to exist, got %s", r.Target)
+	}
+}
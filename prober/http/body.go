@@ -0,0 +1,80 @@
+package http
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/utils"
+	"go.uber.org/zap"
+	"io"
+	"strings"
+)
+
+var errBodySizeLimitExceeded = errors.New("body size limit exceeded")
+
+// getDecompressionReader 根据配置的压缩算法返回解压后的reader
+// 配置了compression时，无论服务端是否真的压缩都按该算法解压，解压失败即探测失败
+// 调用方需要Close返回的reader以释放解压器，e.g. zstd的解码goroutine，origin 需要单独Close
+func getDecompressionReader(algorithm string, origin io.Reader) (io.ReadCloser, error) {
+	switch strings.ToLower(algorithm) {
+	case "br":
+		return io.NopCloser(brotli.NewReader(origin)), nil
+	case "deflate":
+		// HTTP 中的 deflate 实际是 zlib 格式（RFC 9110）
+		return zlib.NewReader(origin)
+	case "gzip":
+		return gzip.NewReader(origin)
+	case "zstd":
+		dec, err := zstd.NewReader(origin)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case "identity", "":
+		return io.NopCloser(origin), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression algorithm %q", algorithm)
+	}
+}
+
+// recordBodyReadFailure 记录读取body失败的原因，超出 body_size_limit 和读取超时需要区分开
+func recordBodyReadFailure(ctx context.Context, err error, httpConfig conf.HTTPProbe) {
+	l := logger(ctx)
+	if errors.Is(err, errBodySizeLimitExceeded) {
+		l.Info("Response body size exceeds body_size_limit", zap.Int64("body_size_limit", int64(httpConfig.BodySizeLimit)))
+		utils.RecordFailure(ctx, utils.FailureBodyTooLarge)
+		return
+	}
+	reason := utils.ClassifyError(err)
+	l.Info("Failed to read HTTP response body", zap.String("reason", string(reason)), zap.Error(err))
+	utils.RecordFailure(ctx, reason)
+}
+
+// limitReader 限制读取的字节数，超过limit时返回 errBodySizeLimitExceeded 而不是静默截断
+type limitReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (lr *limitReader) Read(p []byte) (int, error) {
+	if lr.n <= 0 {
+		// 已经读满limit，只要还能读到数据就说明超出了限制
+		var b [1]byte
+		n, err := lr.ReadCloser.Read(b[:])
+		if n > 0 {
+			return 0, errBodySizeLimitExceeded
+		}
+		return 0, err
+	}
+	if int64(len(p)) > lr.n {
+		p = p[:lr.n]
+	}
+	n, err := lr.ReadCloser.Read(p)
+	lr.n -= int64(n)
+	return n, err
+}
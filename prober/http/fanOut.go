@@ -0,0 +1,104 @@
+package http
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/utils"
+	"go.uber.org/zap"
+	"sync"
+)
+
+// probeHTTPFanOut 解析target的所有地址并发探测，每个地址的metrics带有ip label
+// 按 fan_out.success_policy 汇总结果，失败时记录第一个失败地址的原因
+func probeHTTPFanOut(ctx context.Context, target string, module conf.Module, registry *prometheus.Registry) bool {
+	var (
+		ipSuccessGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_http_ip_success",
+			Help: "Displays whether or not the probe of every resolved ip address was a success",
+		}, []string{"ip"})
+
+		ipFailureReasonGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_http_ip_failure_reason",
+			Help: "Indicates why the probe of a resolved ip address failed, only exported when it failed",
+		}, []string{"ip", "reason"})
+
+		ipsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_http_fan_out_ips",
+			Help: "Returns the number of resolved ip addresses that were probed",
+		})
+
+		ipsSucceededGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_http_fan_out_ips_succeeded",
+			Help: "Returns the number of resolved ip addresses whose probe was a success",
+		})
+	)
+	registry.MustRegister(ipSuccessGaugeVec, ipFailureReasonGaugeVec, ipsGauge, ipsSucceededGauge)
+
+	l := logger(ctx)
+	httpConfig := module.HTTP
+
+	_, targetHost, _, err := urlParse(AdjustTarget(target))
+	if err != nil {
+		l.Error("Could not parse target URL", zap.Error(err))
+		utils.RecordFailure(ctx, utils.FailureInvalidTarget)
+		return false
+	}
+
+	ips, _, err := conf.ResolveAll(ctx, httpConfig.IPProtocol, httpConfig.IPProtocolFallback, targetHost, registry)
+	if err != nil {
+		l.Error("Error resolving address", zap.Error(err))
+		return false
+	}
+	ipsGauge.Set(float64(len(ips)))
+
+	results := make([]bool, len(ips))
+	reasons := make([]utils.FailureReason, len(ips))
+	var wg sync.WaitGroup
+	for i := range ips {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ip := ips[i]
+			// 每个地址使用单独的失败原因和带有ip的logger，metrics都加上ip label
+			ipCtx, failureReason := utils.NewFailureContext(ctx)
+			ipCtx = utils.WithLogger(ipCtx, utils.LoggerFromContext(ctx, "").With(zap.String("ip", ip.String())))
+			ipRegistry := prometheus.WrapRegistererWith(prometheus.Labels{"ip": ip.String()}, registry)
+			results[i] = probeHTTP(ipCtx, target, module, ipRegistry, &ip)
+			reasons[i] = failureReason()
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	var firstReason utils.FailureReason
+	for i, ip := range ips {
+		if results[i] {
+			succeeded++
+			ipSuccessGaugeVec.WithLabelValues(ip.String()).Set(1)
+			continue
+		}
+		reason := reasons[i]
+		if reason == "" {
+			reason = utils.FailureUnknown
+		}
+		if firstReason == "" {
+			firstReason = reason
+		}
+		ipSuccessGaugeVec.WithLabelValues(ip.String()).Set(0)
+		ipFailureReasonGaugeVec.WithLabelValues(ip.String(), string(reason)).Set(1)
+	}
+	ipsSucceededGauge.Set(float64(succeeded))
+
+	policy := httpConfig.FanOut.SuccessPolicy
+	if policy == "" {
+		policy = conf.FanOutPolicyAll
+	}
+	if !httpConfig.FanOut.Succeeded(succeeded, len(ips)) {
+		l.Error("Fan out probe failed", zap.String("success_policy", policy), zap.Int("succeeded", succeeded), zap.Int("ips", len(ips)))
+		utils.RecordFailure(ctx, firstReason)
+		return false
+	}
+	l.Info("Fan out probe succeeded", zap.String("success_policy", policy), zap.Int("succeeded", succeeded), zap.Int("ips", len(ips)))
+	return true
+}
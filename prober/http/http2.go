@@ -0,0 +1,108 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	pconfig "github.com/prometheus/common/config"
+	"golang.org/x/net/http2"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+)
+
+// http2RoundTripper 强制使用HTTP/2
+// https 通过ALPN只协商h2，http 使用 h2c prior knowledge（不经过Upgrade直接发送HTTP/2帧）
+type http2RoundTripper struct {
+	tls *http2.Transport
+	h2c *http2.Transport
+}
+
+func (rt *http2RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" {
+		return rt.h2c.RoundTrip(req)
+	}
+	return rt.tls.RoundTrip(req)
+}
+
+// CloseIdleConnections 探测结束后关闭连接，效果等同于 WithKeepAlivesDisabled
+func (rt *http2RoundTripper) CloseIdleConnections() {
+	rt.tls.CloseIdleConnections()
+	rt.h2c.CloseIdleConnections()
+}
+
+// newHTTP2RoundTripper 根据 HTTPClientConfig 生成强制HTTP/2的RoundTripper
+// 认证方式与 prometheus common 生成的RoundTripper保持一致，proxy 和 oauth2 在配置校验时已经被拒绝
+func newHTTP2RoundTripper(cfg pconfig.HTTPClientConfig) (http.RoundTripper, *http2RoundTripper, error) {
+	tlsConfig, err := pconfig.NewTLSConfig(&cfg.TLSConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsConfig.NextProtos = []string{http2.NextProtoTLS}
+
+	h2 := &http2RoundTripper{
+		tls: &http2.Transport{
+			TLSClientConfig: tlsConfig,
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				conn, err := dialWithTrace(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				return handshakeWithTrace(ctx, conn, cfg)
+			},
+		},
+		h2c: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialWithTrace(ctx, network, addr)
+			},
+		},
+	}
+
+	var rt http.RoundTripper = h2
+	if cfg.Authorization != nil && len(cfg.Authorization.Credentials) > 0 {
+		rt = pconfig.NewAuthorizationCredentialsRoundTripper(cfg.Authorization.Type, cfg.Authorization.Credentials, rt)
+	} else if cfg.Authorization != nil && len(cfg.Authorization.CredentialsFile) > 0 {
+		rt = pconfig.NewAuthorizationCredentialsFileRoundTripper(cfg.Authorization.Type, cfg.Authorization.CredentialsFile, rt)
+	}
+	if len(cfg.BearerToken) > 0 {
+		rt = pconfig.NewAuthorizationCredentialsRoundTripper("Bearer", cfg.BearerToken, rt)
+	} else if len(cfg.BearerTokenFile) > 0 {
+		rt = pconfig.NewAuthorizationCredentialsFileRoundTripper("Bearer", cfg.BearerTokenFile, rt)
+	}
+	if cfg.BasicAuth != nil {
+		rt = pconfig.NewBasicAuthRoundTripper(cfg.BasicAuth.Username, cfg.BasicAuth.Password, cfg.BasicAuth.PasswordFile, rt)
+	}
+	return rt, h2, nil
+}
+
+// dialWithTrace http2.Transport 使用自定义的dial时不会触发 ConnectStart/ConnectDone，这里手动触发
+func dialWithTrace(ctx context.Context, network, addr string) (net.Conn, error) {
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.ConnectStart != nil {
+		trace.ConnectStart(network, addr)
+	}
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if trace != nil && trace.ConnectDone != nil {
+		trace.ConnectDone(network, addr, err)
+	}
+	return conn, err
+}
+
+// handshakeWithTrace 完成TLS握手并触发 TLSHandshakeStart/TLSHandshakeDone
+func handshakeWithTrace(ctx context.Context, conn net.Conn, cfg *tls.Config) (net.Conn, error) {
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+	tlsConn := tls.Client(conn, cfg)
+	err := tlsConn.HandshakeContext(ctx)
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		trace.TLSHandshakeDone(tlsConn.ConnectionState(), err)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
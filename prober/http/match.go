@@ -0,0 +1,95 @@
+package http
+
+import (
+	"context"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/utils"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/textproto"
+)
+
+// matchRegularExpressions 校验response body是否满足 fail_if_body_matches_regexp/fail_if_body_not_matches_regexp
+func matchRegularExpressions(ctx context.Context, reader io.Reader, httpConfig conf.HTTPProbe) bool {
+	l := logger(ctx)
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		recordBodyReadFailure(ctx, err, httpConfig)
+		return false
+	}
+
+	for _, expression := range httpConfig.FailIfBodyMatchesRegexp {
+		if expression.Match(body) {
+			l.Error("Body matched regular expression", zap.String("regexp", expression.String()))
+			utils.RecordFailure(ctx, utils.FailureRegexBody)
+			return false
+		}
+	}
+
+	for _, expression := range httpConfig.FailIfBodyNotMatchesRegexp {
+		if !expression.Match(body) {
+			l.Error("Body did not match regular expression", zap.String("regexp", expression.String()))
+			utils.RecordFailure(ctx, utils.FailureRegexBody)
+			return false
+		}
+	}
+	return true
+}
+
+// matchRegularExpressionsOnHeaders 校验response header是否满足 fail_if_header_matches/fail_if_header_not_matches
+// 同名header可能有多个值：
+// fail_if_header_matches 任意一个值匹配即失败
+// fail_if_header_not_matches 所有值都不匹配才失败
+func matchRegularExpressionsOnHeaders(ctx context.Context, header http.Header, httpConfig conf.HTTPProbe) bool {
+	l := logger(ctx)
+	for _, headerMatchSpec := range httpConfig.FailIfHeaderMatchesRegexp {
+		values := header[textproto.CanonicalMIMEHeaderKey(headerMatchSpec.Header)]
+		if len(values) == 0 {
+			if !headerMatchSpec.AllowMissing {
+				l.Error("Missing required header", zap.String("header", headerMatchSpec.Header))
+				return false
+			}
+			// header不存在时无需再做正则匹配
+			continue
+		}
+
+		for _, val := range values {
+			if headerMatchSpec.Regexp.MatchString(val) {
+				l.Error("Header matched regular expression",
+					zap.String("header", headerMatchSpec.Header),
+					zap.String("regexp", headerMatchSpec.Regexp.String()),
+					zap.Int("value_count", len(values)))
+				return false
+			}
+		}
+	}
+
+	for _, headerMatchSpec := range httpConfig.FailIfHeaderNotMatchesRegexp {
+		values := header[textproto.CanonicalMIMEHeaderKey(headerMatchSpec.Header)]
+		if len(values) == 0 {
+			if !headerMatchSpec.AllowMissing {
+				l.Error("Missing required header", zap.String("header", headerMatchSpec.Header))
+				return false
+			}
+			continue
+		}
+
+		anyHeaderValueMatched := false
+		for _, val := range values {
+			if headerMatchSpec.Regexp.MatchString(val) {
+				anyHeaderValueMatched = true
+				break
+			}
+		}
+
+		if !anyHeaderValueMatched {
+			l.Error("Header did not match regular expression",
+				zap.String("header", headerMatchSpec.Header),
+				zap.String("regexp", headerMatchSpec.Regexp.String()),
+				zap.Int("value_count", len(values)))
+			return false
+		}
+	}
+	return true
+}
@@ -2,11 +2,14 @@ package http
 
 import (
 	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	pconfig "github.com/prometheus/common/config"
 	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/prober/tlscheck"
+	"github.com/yuanyp8/http_exporter/utils"
 	"go.uber.org/zap"
 	"golang.org/x/net/publicsuffix"
 	"golang.org/x/text/cases"
@@ -17,7 +20,9 @@ import (
 	"net/http/cookiejar"
 	"net/http/httptrace"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // AdjustTarget 校验target格式
@@ -34,10 +39,20 @@ func urlParse(src string) (dest *url.URL, host, port string, err error) {
 	if err != nil {
 		return nil, "", "", err
 	}
-	return dest, dest.Host, dest.Port(), err
+	return dest, dest.Hostname(), dest.Port(), err
 }
 
-func ProbeHTTP(ctx context.Context, target string, module conf.Module, registry *prometheus.Registry) (success bool) {
+// ProbeHTTP 探测http target，开启 fan_out 时并发探测target解析出的每个地址
+func ProbeHTTP(ctx context.Context, target string, module conf.Module, registry *prometheus.Registry) bool {
+	if module.HTTP.FanOut.Enabled {
+		return probeHTTPFanOut(ctx, target, module, registry)
+	}
+	return probeHTTP(ctx, target, module, registry, nil)
+}
+
+// probeHTTP 完成一次http探测，resolved 不为nil时直接请求该地址，不再解析target
+func probeHTTP(ctx context.Context, target string, module conf.Module, registry prometheus.Registerer, resolved *net.IPAddr) (success bool) {
+	l := logger(ctx)
 
 	var (
 		durationGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
@@ -70,46 +85,72 @@ func ProbeHTTP(ctx context.Context, target string, module conf.Module, registry
 			Help: "Response HTTP status code",
 		})
 
-		probeSSLEarliestCertExpiryGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "probe_ssl_earliest_cert_expiry",
-			Help: "Returns earliest SSL cert expiry in unixtime",
+		probeHTTPVersionGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_http_version",
+			Help: "Returns the version of HTTP of the probe response",
+		})
+
+		probeFailedDueToRegex = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_failed_due_to_regex",
+			Help: "Indicates if probe failed due to regex",
 		})
 
-		probeSSLLastChainExpiryTimestampSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "probe_ssl_last_chain_expiry_timestamp_seconds",
-			Help: "Returns last SSL chain expiry in timestamp seconds",
+		probeHTTPLastModified = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_http_last_modified_timestamp_seconds",
+			Help: "Returns the Last-Modified HTTP response header in unixtime",
 		})
 
-		probeSSLLastInformation = prometheus.NewGaugeVec(
+		probeHTTPProtocolInfo = prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
-				Name: "probe_ssl_last_chain_info",
-				Help: "Contains SSL leaf certificate information",
+				Name: "probe_http_protocol_info",
+				Help: "Contains the HTTP protocol of the probe response",
 			},
-			[]string{"fingerprint_sha256"},
+			[]string{"protocol"},
 		)
 
-		probeTLSVersion = prometheus.NewGaugeVec(
+		probeTLSALPNProtocolInfo = prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
-				Name: "probe_tls_version_info",
-				Help: "Contains the TLS version used",
+				Name: "probe_tls_alpn_protocol_info",
+				Help: "Contains the protocol negotiated by TLS ALPN, empty if none was negotiated",
 			},
-			[]string{"version"},
+			[]string{"protocol"},
 		)
 
-		probeHTTPVersionGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "probe_http_version",
-			Help: "Returns the version of HTTP of the probe response",
-		})
+		hopDurationGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_http_redirect_hop_duration_seconds",
+			Help: "Duration of http request by phase for every hop, hop 0 is the initial request",
+		}, []string{"hop", "phase"})
 
-		probeFailedDueToRegex = prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "probe_failed_due_to_regex",
-			Help: "Indicates if probe failed due to regex",
+		hopStatusCodeGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_http_redirect_hop_status_code",
+			Help: "Response HTTP status code for every hop, hop 0 is the initial request",
+		}, []string{"hop", "host"})
+
+		probeTLSPolicyViolation = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "probe_tls_policy_violation",
+				Help: "Indicates if the final request violated the configured TLS policy",
+			},
+			[]string{"reason"},
+		)
+
+		probeSSLVerified = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_ssl_verified",
+			Help: "Indicates if the presented certificate chain verifies against the configured or system roots, regardless of insecure_skip_verify",
 		})
 
-		probeHTTPLastModified = prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "probe_http_last_modified_timestamp_seconds",
-			Help: "Returns the Last-Modified HTTP response header in unixtime",
+		probeSSLHostnameMatch = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_ssl_hostname_match",
+			Help: "Indicates if the presented leaf certificate is valid for the expected hostname",
 		})
+
+		probeSSLVerifyError = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "probe_ssl_verify_error_info",
+				Help: "Contains the reason the presented certificate chain failed verification",
+			},
+			[]string{"reason"},
+		)
 	)
 	registry.MustRegister(
 		durationGaugeVec,
@@ -120,16 +161,20 @@ func ProbeHTTP(ctx context.Context, target string, module conf.Module, registry
 		statusCodeGauge,
 		probeHTTPVersionGauge,
 		probeFailedDueToRegex,
-		probeSSLEarliestCertExpiryGauge,
 		probeHTTPLastModified,
-		probeTLSVersion,
-		probeSSLLastInformation,
-		probeSSLLastChainExpiryTimestampSeconds
+		probeHTTPProtocolInfo,
+		probeTLSALPNProtocolInfo,
+		hopDurationGaugeVec,
+		hopStatusCodeGaugeVec,
+		probeTLSPolicyViolation,
 	)
 
 	var redirects int
+	// 重定向的某一跳状态码不满足 valid_redirect_status_code
+	var redirectRejected bool
 
-	httpConfig := module.HTTP
+	// 拷贝一份模块配置，避免并发探测时互相修改
+	httpConfig := *module.HTTP
 	httpClientConfig := module.HTTP.HTTPClientConfig
 
 	// 自动加上http头
@@ -138,14 +183,18 @@ func ProbeHTTP(ctx context.Context, target string, module conf.Module, registry
 	targetUrl, targetHost, targetPort, err := urlParse(target)
 	if err != nil {
 		l.Error("Could not parse target URL", zap.Error(err))
+		utils.RecordFailure(ctx, utils.FailureInvalidTarget)
 		return
 	}
 
 	// 在没有proxy的情况下进行域名解析
-	ip, err := httpConfig.LookUpWithoutProxy(ctx, target, durationGaugeVec)
-	if err != nil {
-		l.Error("Error resolving address", zap.Error(err))
-		return false
+	ip := resolved
+	if ip == nil {
+		ip, err = httpConfig.LookUpWithoutProxy(ctx, targetHost, durationGaugeVec, registry)
+		if err != nil {
+			l.Error("Error resolving address", zap.Error(err))
+			return false
+		}
 	}
 
 	// 大写，替代strings.Upper
@@ -164,39 +213,92 @@ func ProbeHTTP(ctx context.Context, target string, module conf.Module, registry
 		}
 	}
 
+	// 第一跳校验证书时期望的hostname
+	verifyHostname := httpClientConfig.TLSConfig.ServerName
+
 	// 基于prometheus的common config生成一个http client，主要作用是配置好了认证服务， e.g. basic auth
 	client, err := pconfig.NewClientFromConfig(httpClientConfig, "http_probe", pconfig.WithKeepAlivesDisabled())
 	if err != nil {
 		l.Error("Error generating HTTP client", zap.Error(err))
+		utils.RecordFailure(ctx, utils.FailureConfig)
 		return false
 	}
 
+	// 强制HTTP/2时替换底层的RoundTripper
+	if httpConfig.ForceHTTP2 {
+		rt, h2, err := newHTTP2RoundTripper(httpClientConfig)
+		if err != nil {
+			l.Error("Error generating HTTP/2 client", zap.Error(err))
+			utils.RecordFailure(ctx, utils.FailureConfig)
+			return false
+		}
+		defer h2.CloseIdleConnections()
+		client.Transport = rt
+	}
+
 	// host置为空，开始准备NoServerName的情况
 	httpClientConfig.TLSConfig.ServerName = ""
 
 	noServerName, err := pconfig.NewRoundTripperFromConfig(httpClientConfig, "http_probe", pconfig.WithKeepAlivesDisabled())
 	if err != nil {
 		l.Error("Error generating HTTP client without ServerName", zap.Error(err))
+		utils.RecordFailure(ctx, utils.FailureConfig)
 		return false
 	}
 
+	if httpConfig.ForceHTTP2 {
+		rt, h2, err := newHTTP2RoundTripper(httpClientConfig)
+		if err != nil {
+			l.Error("Error generating HTTP/2 client without ServerName", zap.Error(err))
+			utils.RecordFailure(ctx, utils.FailureConfig)
+			return false
+		}
+		defer h2.CloseIdleConnections()
+		noServerName = rt
+	}
+
 	// 设置http client的cookie
 	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
 	if err != nil {
 		l.Error("Error generating cookiejar", zap.Error(err))
+		utils.RecordFailure(ctx, utils.FailureConfig)
 		return false
 	}
 	client.Jar = jar
 
-
 	tt := newTransport(client.Transport, noServerName)
 	client.Transport = tt
 
 	client.CheckRedirect = func(r *http.Request, via []*http.Request) error {
 		l.Info("Received redirect", zap.String("location", r.Response.Header.Get("Location")))
+		if len(httpConfig.ValidRedirectStatusCode) > 0 {
+			if ok, rule := httpConfig.ValidRedirectStatusCode.Match(r.Response.StatusCode); !ok {
+				l.Info("Invalid HTTP redirect status code, not following redirect",
+					zap.Int("hop", len(via)),
+					zap.Int("status_code", r.Response.StatusCode),
+					zap.String("rejected_by", rule))
+				redirectRejected = true
+				utils.RecordFailure(ctx, utils.FailureRedirect)
+				return errors.New("invalid redirect status code")
+			}
+		}
+		// 相对地址的跳转URL中host是解析后的ip，需要使用原始的host匹配
+		location := requestURL(r)
+		for _, expression := range httpConfig.FailIfRedirectLocationNotMatchesRegexp {
+			if !expression.MatchString(location) {
+				l.Info("Redirect location did not match regular expression, not following redirect",
+					zap.Int("hop", len(via)),
+					zap.String("location", location),
+					zap.String("regexp", expression.String()))
+				redirectRejected = true
+				probeFailedDueToRegex.Set(1)
+				utils.RecordFailure(ctx, utils.FailureRegexURL)
+				return errors.New("redirect location did not match regular expression")
+			}
+		}
 		redirects = len(via)
-		if redirects > 10 || !httpConfig.HTTPClientConfig.FollowRedirects {
-			l.Info("Not following redirect")
+		if redirects > httpConfig.MaxRedirects || !httpConfig.HTTPClientConfig.FollowRedirects {
+			l.Info("Not following redirect", zap.Int("redirects", redirects), zap.Int("max_redirects", httpConfig.MaxRedirects))
 			return errors.New("don't follow redirects")
 		}
 		return nil
@@ -219,7 +321,8 @@ func ProbeHTTP(ctx context.Context, target string, module conf.Module, registry
 		}
 	}
 	var body io.Reader
-	var respBodyBytes int64
+	// 实际从连接上读取到的body字节数，-1 表示没有读取body
+	var respBodyBytes int64 = -1
 
 	// If a body is configured, add it to the request.
 	if httpConfig.Body != "" {
@@ -229,6 +332,7 @@ func ProbeHTTP(ctx context.Context, target string, module conf.Module, registry
 	request, err := http.NewRequest(httpConfig.Method, targetUrl.String(), body)
 	if err != nil {
 		l.Error("Error creating request", zap.Error(err))
+		utils.RecordFailure(ctx, utils.FailureInvalidTarget)
 		return
 	}
 
@@ -245,6 +349,11 @@ func ProbeHTTP(ctx context.Context, target string, module conf.Module, registry
 		request.Header.Set(key, value)
 	}
 
+	// 配置了压缩算法时需要自己声明 Accept-Encoding，此时 transport 不会再自动解压 gzip
+	if httpConfig.Compression != "" {
+		request.Header.Set("Accept-Encoding", httpConfig.Compression)
+	}
+
 	// 设置默认User-Agent
 	_, hasUserAgent := request.Header["User-Agent"]
 	if !hasUserAgent {
@@ -271,39 +380,244 @@ func ProbeHTTP(ctx context.Context, target string, module conf.Module, registry
 
 	resp, err := client.Do(request)
 
+	// 无论请求是否成功、是否跳过了校验，都独立校验最后一跳服务端发送的证书链
+	if certs := presentedCertificates(resp, err); len(certs) > 0 {
+		tt.mu.Lock()
+		// 重定向到其它地址时期望的hostname是最后一跳的host
+		if last := tt.traces[len(tt.traces)-1]; last.noServerName {
+			verifyHostname = last.host
+			if host, _, err := net.SplitHostPort(last.host); err == nil {
+				verifyHostname = host
+			}
+		}
+		tt.mu.Unlock()
+		registry.MustRegister(probeSSLVerified, probeSSLHostnameMatch, probeSSLVerifyError)
+		verifyPresentedChain(ctx, certs, httpConfig.HTTPClientConfig.TLSConfig, verifyHostname, probeSSLVerified, probeSSLHostnameMatch, probeSSLVerifyError)
+	}
+
 	if resp == nil {
 		resp = &http.Response{}
 		if err != nil {
-			l.Error("Error for HTTP request", zap.Error(err))
+			reason := utils.ClassifyError(err)
+			l.Error("Error for HTTP request", zap.String("reason", string(reason)), zap.Error(err))
+			utils.RecordFailure(ctx, reason)
 		}
 	} else {
 		requestErrored := (err != nil)
 		l.Info("Received HTTP response", zap.Int("status_code", resp.StatusCode))
-		if len(httpConfig.ValidStatusCode) != 0 {
-			for _, code := range httpConfig.ValidStatusCode {
-				if resp.StatusCode == code {
-					success = true
+		// 未配置 valid_status_code 时默认只接受2xx
+		var rule string
+		if success, rule = httpConfig.ValidStatusCode.Match(resp.StatusCode); !success {
+			l.Info("Invalid HTTP response status code",
+				zap.Int("status_code", resp.StatusCode),
+				zap.String("rejected_by", rule))
+			utils.RecordFailure(ctx, utils.FailureStatusCode)
+		}
+		if redirectRejected {
+			success = false
+		}
+
+		if success && len(httpConfig.ValidHTTPVersions) != 0 {
+			found := false
+			for _, version := range httpConfig.ValidHTTPVersions {
+				if version == resp.Proto {
+					found = true
 					break
 				}
 			}
-			if !success {
-				l.Info("Invalid HTTP response status code",
-					zap.Int("status_code", resp.StatusCode),
-					zap.String("valid_status_codes", fmt.Sprintf("%v", httpConfig.ValidStatusCode)))
+			if !found {
+				l.Error("Invalid HTTP version number",
+					zap.String("version", resp.Proto),
+					zap.Strings("valid_http_versions", httpConfig.ValidHTTPVersions))
+				utils.RecordFailure(ctx, utils.FailureHTTPVersion)
+				success = false
 			}
-		} else if 20 <= resp.StatusCode && resp.StatusCode < 300 {
-			success = true
-		} else {
-			l.Info("Invalid HTTP response status code, wanted 2xx", zap.Int("status_code", resp.StatusCode))
 		}
 
 		if success && (len(httpConfig.FailIfHeaderMatchesRegexp) > 0 || len(httpConfig.FailIfHeaderNotMatchesRegexp) > 0) {
+			success = matchRegularExpressionsOnHeaders(ctx, resp.Header, httpConfig)
+			if success {
+				probeFailedDueToRegex.Set(0)
+			} else {
+				probeFailedDueToRegex.Set(1)
+				utils.RecordFailure(ctx, utils.FailureRegexHeader)
+			}
+		}
+
+		if !requestErrored {
+			// wire 统计实际传输的（可能是压缩后的）字节数，bc 统计解压后的字节数
+			wire := &byteCounter{ReadCloser: resp.Body}
+
+			reader, err := getDecompressionReader(httpConfig.Compression, wire)
+			if err != nil {
+				l.Info("Failed to get decompressor for HTTP response body", zap.String("compression", httpConfig.Compression), zap.Error(err))
+				utils.RecordFailure(ctx, utils.FailureDecompression)
+				success = false
+			} else {
+				// body_size_limit 限制的是解压后的大小，防止被压缩炸弹撑爆内存
+				if httpConfig.BodySizeLimit > 0 {
+					reader = &limitReader{ReadCloser: reader, n: int64(httpConfig.BodySizeLimit)}
+				}
+				bc := &byteCounter{ReadCloser: reader}
+
+				if success && (len(httpConfig.FailIfBodyMatchesRegexp) > 0 || len(httpConfig.FailIfBodyNotMatchesRegexp) > 0) {
+					success = matchRegularExpressions(ctx, bc, httpConfig)
+					if success {
+						probeFailedDueToRegex.Set(0)
+					} else {
+						probeFailedDueToRegex.Set(1)
+					}
+				}
 
+				// 读完body，保证transfer阶段的耗时被完整记录
+				if _, err = io.Copy(io.Discard, bc); err != nil {
+					recordBodyReadFailure(ctx, err, httpConfig)
+					success = false
+				}
+				bodyUncompressedLengthGauge.Set(float64(bc.n))
+				// 先关闭解压器并等待它的goroutine退出，之后才能关闭body和读取 wire.n
+				reader.Close()
+			}
+			wire.Close()
+
+			// body已经读完，记录本次round trip的结束时间
+			tt.mu.Lock()
+			tt.current.end = time.Now()
+			tt.mu.Unlock()
+
+			respBodyBytes = wire.n
 		}
 
+		// e.g. HTTP/1.1 => 1.1, HTTP/2.0 => 2
+		probeHTTPVersionGauge.Set(float64(resp.ProtoMajor) + float64(resp.ProtoMinor)/10)
+		probeHTTPProtocolInfo.WithLabelValues(resp.Proto).Set(1)
+
+		if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+			if t, err := http.ParseTime(lastModified); err == nil {
+				probeHTTPLastModified.Set(float64(t.Unix()))
+			} else {
+				l.Info("Error parsing Last-Modified header", zap.String("last_modified", lastModified), zap.Error(err))
+			}
+		}
 	}
 
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	for i, trace := range tt.traces {
+		l.Info("Response timings for roundtrip",
+			zap.Int("roundtrip", i),
+			zap.String("url", trace.url),
+			zap.Int("status_code", trace.statusCode),
+			zap.Time("start", trace.start),
+			zap.Time("dnsDone", trace.dnsDone),
+			zap.Time("connectDone", trace.connectDone),
+			zap.Time("gotConn", trace.gotDone),
+			zap.Time("responseStart", trace.responseStart),
+			zap.Time("tlsStart", trace.tlsStart),
+			zap.Time("tlsDone", trace.tlsDone),
+			zap.Time("end", trace.end))
+		l.Debug("Request headers for roundtrip", zap.Int("roundtrip", i), zap.String("url", trace.url), zap.Any("headers", utils.RedactHeader(trace.reqHeader)))
+		if trace.respHeader != nil {
+			l.Debug("Response headers for roundtrip",
+				zap.Int("roundtrip", i),
+				zap.String("proto", trace.proto),
+				zap.Int("status_code", trace.statusCode),
+				zap.Any("headers", utils.RedactHeader(trace.respHeader)))
+		}
+
+		hop := strconv.Itoa(i)
+		if trace.statusCode != 0 {
+			hopStatusCodeGaugeVec.WithLabelValues(hop, trace.host).Set(float64(trace.statusCode))
+		}
 
+		// 同时累加到总耗时和本跳的耗时
+		addPhase := func(phase string, seconds float64) {
+			durationGaugeVec.WithLabelValues(phase).Add(seconds)
+			hopDurationGaugeVec.WithLabelValues(hop, phase).Set(seconds)
+		}
+
+		// 只累加两端都记录了的阶段，e.g. 连接失败时没有 gotDone，避免出现负数
+		addPhaseBetween := func(phase string, from, to time.Time) {
+			if !from.IsZero() && !to.IsZero() {
+				addPhase(phase, to.Sub(from).Seconds())
+			}
+		}
+
+		if trace.reused {
+			// 复用已有的连接，没有解析和建立连接的过程
+			if i != 0 {
+				addPhase("resolve", 0)
+			}
+			addPhase("connect", 0)
+		} else {
+			// 第一次请求的dns解析时间已经在 LookUpWithoutProxy 中记录，这里只累加重定向产生的解析时间
+			if i != 0 {
+				addPhaseBetween("resolve", trace.start, trace.dnsDone)
+			}
+			if trace.tls {
+				// tls握手在建立tcp连接之后，connect阶段以tcp连接建立为结束
+				addPhaseBetween("connect", trace.dnsDone, trace.connectDone)
+				addPhaseBetween("tls", trace.tlsStart, trace.tlsDone)
+			} else {
+				addPhaseBetween("connect", trace.dnsDone, trace.gotDone)
+			}
+		}
+
+		// 没有收到服务端的响应时 responseStart 为零值，没有完整读取到响应时 end 为零值
+		addPhaseBetween("processing", trace.gotDone, trace.responseStart)
+		addPhaseBetween("transfer", trace.responseStart, trace.end)
+	}
+
+	// 校验最终请求的URL，e.g. 必须以https结束
+	if len(tt.traces) > 0 && resp.StatusCode != 0 && len(httpConfig.FailIfFinalURLNotMatchesRegexp) > 0 {
+		finalURL := tt.traces[len(tt.traces)-1].url
+		for _, expression := range httpConfig.FailIfFinalURLNotMatchesRegexp {
+			if !expression.MatchString(finalURL) {
+				l.Error("Final URL did not match regular expression", zap.String("url", finalURL), zap.String("regexp", expression.String()))
+				success = false
+				probeFailedDueToRegex.Set(1)
+				utils.RecordFailure(ctx, utils.FailureRegexURL)
+				break
+			}
+		}
+	}
+
+	if resp.TLS != nil {
+		isSSLGauge.Set(1)
+		utils.RegisterTLSStateMetrics(registry, resp.TLS)
+		probeTLSALPNProtocolInfo.WithLabelValues(resp.TLS.NegotiatedProtocol).Set(1)
+	}
+
+	if resp.TLS != nil && !tlscheck.CheckRevocation(ctx, httpConfig.Revocation, httpConfig.HTTPClientConfig, resp.TLS, registry) {
+		success = false
+	}
+
+	// 只有收到响应时才校验pin，没有使用TLS时视为不匹配
+	if resp.StatusCode != 0 {
+		var certs []*x509.Certificate
+		if resp.TLS != nil {
+			certs = resp.TLS.PeerCertificates
+		}
+		if !tlscheck.CheckPins(ctx, httpConfig.CertificatePins, certs, registry) {
+			success = false
+		}
+	}
+
+	// 只有收到响应时才校验TLS策略
+	if resp.StatusCode != 0 && !checkTLSPolicy(ctx, resp.TLS, httpConfig, probeTLSPolicyViolation) {
+		utils.RecordFailure(ctx, utils.FailureTLSPolicy)
+		success = false
+	}
+
+	statusCodeGauge.Set(float64(resp.StatusCode))
+	// 没有Content-Length（e.g. chunked）时，使用实际读取到的字节数
+	// transport 自动解压gzip时读取到的已经是解压后的数据，无法得知压缩后的大小
+	if resp.ContentLength < 0 && respBodyBytes >= 0 && !resp.Uncompressed {
+		contentLengthGauge.Set(float64(respBodyBytes))
+	} else {
+		contentLengthGauge.Set(float64(resp.ContentLength))
+	}
+	redirectsGauge.Set(float64(redirects))
 
 	return
 }
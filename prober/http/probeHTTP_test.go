@@ -1,12 +1,1214 @@
 package http
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
 	"fmt"
+	"github.com/alecthomas/units"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/utils"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestAdjustTarget(t *testing.T) {
-	b := "accc.com"
-	AdjustTarget(&b)
+	b := AdjustTarget("accc.com")
 	fmt.Println(b)
 }
+
+func newTestModule(httpProbe *conf.HTTPProbe) conf.Module {
+	return conf.Module{
+		Prober:  "http",
+		Timeout: time.Second,
+		HTTP:    httpProbe,
+	}
+}
+
+func TestFailIfBodyMatchesRegexp(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		matches        []conf.Regexp
+		notMatches     []conf.Regexp
+		expectedResult bool
+	}{
+		{"body matches regexp", "internal server error: failed", []conf.Regexp{*conf.MustNewRegexp("failed")}, nil, false},
+		{"body does not match regexp", "all good", []conf.Regexp{*conf.MustNewRegexp("failed")}, nil, true},
+		{"body matches one of regexps", "could not connect to database", []conf.Regexp{*conf.MustNewRegexp("failed"), *conf.MustNewRegexp("could not connect")}, nil, false},
+		{"body matches required regexp", "Download the latest version here", nil, []conf.Regexp{*conf.MustNewRegexp("Download the latest version here")}, true},
+		{"body misses required regexp", "Service unavailable", nil, []conf.Regexp{*conf.MustNewRegexp("Download the latest version here")}, false},
+		{"body matches all required regexps", "Copyright 2022, Download the latest version here", nil, []conf.Regexp{*conf.MustNewRegexp("Download"), *conf.MustNewRegexp("Copyright")}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, test.body)
+			}))
+			defer ts.Close()
+
+			httpProbe := conf.NewDefaultHTTPProbe()
+			httpProbe.FailIfBodyMatchesRegexp = test.matches
+			httpProbe.FailIfBodyNotMatchesRegexp = test.notMatches
+
+			registry := prometheus.NewRegistry()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			result := ProbeHTTP(ctx, ts.URL, newTestModule(httpProbe), registry)
+			if result != test.expectedResult {
+				t.Fatalf("Regexp test failed unexpectedly, got %t, want %t", result, test.expectedResult)
+			}
+			checkFailedDueToRegex(t, registry, !test.expectedResult)
+		})
+	}
+}
+
+func TestFailIfHeaderMatchesRegexp(t *testing.T) {
+	tests := []struct {
+		name           string
+		rule           conf.HeaderMatch
+		values         []string
+		expectedResult bool
+	}{
+		{"header matches", conf.HeaderMatch{Header: "Content-Type", Regexp: *conf.MustNewRegexp("text/javascript")}, []string{"text/javascript"}, false},
+		{"header does not match", conf.HeaderMatch{Header: "Content-Type", Regexp: *conf.MustNewRegexp("text/javascript")}, []string{"application/json"}, true},
+		{"header missing", conf.HeaderMatch{Header: "X-Custom", Regexp: *conf.MustNewRegexp("text/javascript")}, nil, false},
+		{"header missing but allowed", conf.HeaderMatch{Header: "X-Custom", Regexp: *conf.MustNewRegexp("text/javascript"), AllowMissing: true}, nil, true},
+		{"one of multiple values matches", conf.HeaderMatch{Header: "Set-Cookie", Regexp: *conf.MustNewRegexp("foo=bar")}, []string{"a=b", "foo=bar"}, false},
+		{"none of multiple values matches", conf.HeaderMatch{Header: "Set-Cookie", Regexp: *conf.MustNewRegexp("foo=bar")}, []string{"a=b", "c=d"}, true},
+		{"header key is case insensitive", conf.HeaderMatch{Header: "content-type", Regexp: *conf.MustNewRegexp("text/javascript")}, []string{"text/javascript"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for _, v := range test.values {
+					w.Header().Add(test.rule.Header, v)
+				}
+			}))
+			defer ts.Close()
+
+			httpProbe := conf.NewDefaultHTTPProbe()
+			httpProbe.FailIfHeaderMatchesRegexp = []conf.HeaderMatch{test.rule}
+
+			registry := prometheus.NewRegistry()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			result := ProbeHTTP(ctx, ts.URL, newTestModule(httpProbe), registry)
+			if result != test.expectedResult {
+				t.Fatalf("Header test failed unexpectedly, got %t, want %t", result, test.expectedResult)
+			}
+			checkFailedDueToRegex(t, registry, !test.expectedResult)
+		})
+	}
+}
+
+func TestFailIfHeaderNotMatchesRegexp(t *testing.T) {
+	tests := []struct {
+		name           string
+		rule           conf.HeaderMatch
+		values         []string
+		expectedResult bool
+	}{
+		{"header matches", conf.HeaderMatch{Header: "Access-Control-Allow-Origin", Regexp: *conf.MustNewRegexp(`(\*|example\.com)`)}, []string{"example.com"}, true},
+		{"header does not match", conf.HeaderMatch{Header: "Access-Control-Allow-Origin", Regexp: *conf.MustNewRegexp(`(\*|example\.com)`)}, []string{"foo.com"}, false},
+		{"header missing", conf.HeaderMatch{Header: "Access-Control-Allow-Origin", Regexp: *conf.MustNewRegexp(`(\*|example\.com)`)}, nil, false},
+		{"header missing but allowed", conf.HeaderMatch{Header: "Access-Control-Allow-Origin", Regexp: *conf.MustNewRegexp(`(\*|example\.com)`), AllowMissing: true}, nil, true},
+		{"one of multiple values matches", conf.HeaderMatch{Header: "Set-Cookie", Regexp: *conf.MustNewRegexp("foo=bar")}, []string{"a=b", "foo=bar"}, true},
+		{"none of multiple values matches", conf.HeaderMatch{Header: "Set-Cookie", Regexp: *conf.MustNewRegexp("foo=bar")}, []string{"a=b", "c=d"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for _, v := range test.values {
+					w.Header().Add(test.rule.Header, v)
+				}
+			}))
+			defer ts.Close()
+
+			httpProbe := conf.NewDefaultHTTPProbe()
+			httpProbe.FailIfHeaderNotMatchesRegexp = []conf.HeaderMatch{test.rule}
+
+			registry := prometheus.NewRegistry()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			result := ProbeHTTP(ctx, ts.URL, newTestModule(httpProbe), registry)
+			if result != test.expectedResult {
+				t.Fatalf("Header test failed unexpectedly, got %t, want %t", result, test.expectedResult)
+			}
+			checkFailedDueToRegex(t, registry, !test.expectedResult)
+		})
+	}
+}
+
+// checkFailedDueToRegex 校验 probe_failed_due_to_regex 的取值
+func checkFailedDueToRegex(t *testing.T, registry *prometheus.Registry, failed bool) {
+	t.Helper()
+	expected := 0.0
+	if failed {
+		expected = 1
+	}
+	checkRegistryResults(t, registry, map[string]float64{"probe_failed_due_to_regex": expected})
+}
+
+// checkRegistryResults 校验registry中无label的metrics取值
+func checkRegistryResults(t *testing.T, registry *prometheus.Registry, expected map[string]float64) {
+	t.Helper()
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := make(map[string]float64)
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			if len(m.Label) == 0 {
+				results[mf.GetName()] = m.GetGauge().GetValue()
+			}
+		}
+	}
+	for name, want := range expected {
+		got, ok := results[name]
+		if !ok {
+			t.Fatalf("Expected metric %s not found", name)
+		}
+		if got != want {
+			t.Fatalf("Expected %s to be %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestProbeHTTPMetrics(t *testing.T) {
+	lastModified := time.Date(2022, time.June, 1, 8, 0, 0, 0, time.UTC)
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/final", http.StatusFound)
+			return
+		}
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+		fmt.Fprint(w, "hello world")
+	}))
+	defer ts.Close()
+
+	httpProbe := conf.NewDefaultHTTPProbe()
+	httpProbe.HTTPClientConfig.TLSConfig.InsecureSkipVerify = true
+
+	registry := prometheus.NewRegistry()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if !ProbeHTTP(ctx, ts.URL, newTestModule(httpProbe), registry) {
+		t.Fatal("Probe failed unexpectedly")
+	}
+
+	checkRegistryResults(t, registry, map[string]float64{
+		"probe_http_status_code":                     200,
+		"probe_http_redirects":                       1,
+		"probe_http_ssl":                             1,
+		"probe_http_version":                         1.1,
+		"probe_http_content_length":                  11,
+		"probe_http_uncompressed_body_length":        11,
+		"probe_http_last_modified_timestamp_seconds": float64(lastModified.Unix()),
+		"probe_ssl_earliest_cert_expiry":             float64(ts.Certificate().NotAfter.Unix()),
+	})
+}
+
+func TestCompressedResponse(t *testing.T) {
+	const body = "hello world, hello world, hello world"
+
+	compressors := map[string]func(io.Writer) io.WriteCloser{
+		"gzip":    func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"deflate": func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+		"br":      func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) },
+		"zstd": func(w io.Writer) io.WriteCloser {
+			enc, _ := zstd.NewWriter(w)
+			return enc
+		},
+	}
+
+	for algorithm, newWriter := range compressors {
+		algorithm, newWriter := algorithm, newWriter
+		t.Run(algorithm, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := newWriter(&buf)
+			io.WriteString(w, body)
+			w.Close()
+			compressed := buf.Bytes()
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Accept-Encoding") != algorithm {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				w.Header().Set("Content-Encoding", algorithm)
+				w.Write(compressed)
+			}))
+			defer ts.Close()
+
+			httpProbe := conf.NewDefaultHTTPProbe()
+			httpProbe.Compression = algorithm
+			httpProbe.FailIfBodyNotMatchesRegexp = []conf.Regexp{*conf.MustNewRegexp("hello world")}
+
+			registry := prometheus.NewRegistry()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			if !ProbeHTTP(ctx, ts.URL, newTestModule(httpProbe), registry) {
+				t.Fatal("Probe failed unexpectedly")
+			}
+			checkRegistryResults(t, registry, map[string]float64{
+				"probe_http_content_length":           float64(len(compressed)),
+				"probe_http_uncompressed_body_length": float64(len(body)),
+			})
+		})
+	}
+
+	// 超出 body_size_limit 时没有读完body，zstd的解码goroutine只有在Close后才会退出
+	// GOMAXPROCS 为1时zstd同步解码，不会启动goroutine
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	var buf bytes.Buffer
+	enc, _ := zstd.NewWriter(&buf, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	for i := 0; i < 1<<16; i++ {
+		fmt.Fprintf(enc, "%d %s\n", i, body)
+	}
+	enc.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "zstd")
+		w.Write(buf.Bytes())
+	}))
+	defer ts.Close()
+
+	httpProbe := conf.NewDefaultHTTPProbe()
+	httpProbe.Compression = "zstd"
+	httpProbe.BodySizeLimit = units.KiB
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	goroutines := runtime.NumGoroutine()
+	if ProbeHTTP(ctx, ts.URL, newTestModule(httpProbe), prometheus.NewRegistry()) {
+		t.Fatal("Probe succeeded unexpectedly")
+	}
+	checkGoroutinesNotLeaked(t, goroutines)
+}
+
+// checkGoroutinesNotLeaked 等待探测产生的goroutine退出，超时后仍然多于探测前则失败
+func checkGoroutinesNotLeaked(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("Goroutines leaked after probe: %d before, %d after", before, n)
+	}
+}
+
+func TestInvalidCompressedResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 声明了gzip但返回的是明文
+		w.Header().Set("Content-Encoding", "gzip")
+		fmt.Fprint(w, "not compressed")
+	}))
+	defer ts.Close()
+
+	httpProbe := conf.NewDefaultHTTPProbe()
+	httpProbe.Compression = "gzip"
+
+	registry := prometheus.NewRegistry()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if ProbeHTTP(ctx, ts.URL, newTestModule(httpProbe), registry) {
+		t.Fatal("Probe succeeded unexpectedly")
+	}
+}
+
+func TestBodySizeLimit(t *testing.T) {
+	const body = "0123456789"
+
+	tests := []struct {
+		limit          units.Base2Bytes
+		compression    string
+		expectedResult bool
+	}{
+		{0, "", true},
+		{units.Base2Bytes(len(body)), "", true},
+		{units.Base2Bytes(len(body) - 1), "", false},
+		// 限制作用于解压后的大小
+		{units.Base2Bytes(len(body) - 1), "gzip", false},
+		{units.Base2Bytes(len(body)), "gzip", true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(fmt.Sprintf("limit=%d,compression=%s", test.limit, test.compression), func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if test.compression == "gzip" {
+					w.Header().Set("Content-Encoding", "gzip")
+					gw := gzip.NewWriter(w)
+					io.WriteString(gw, body)
+					gw.Close()
+					return
+				}
+				fmt.Fprint(w, body)
+			}))
+			defer ts.Close()
+
+			httpProbe := conf.NewDefaultHTTPProbe()
+			httpProbe.BodySizeLimit = test.limit
+			httpProbe.Compression = test.compression
+
+			registry := prometheus.NewRegistry()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			if result := ProbeHTTP(ctx, ts.URL, newTestModule(httpProbe), registry); result != test.expectedResult {
+				t.Fatalf("Body size limit test failed unexpectedly, got %t, want %t", result, test.expectedResult)
+			}
+		})
+	}
+}
+
+func TestTLSPolicy(t *testing.T) {
+	tests := []struct {
+		name           string
+		plainHTTP      bool
+		configure      func(h *conf.HTTPProbe)
+		expectedResult bool
+		violation      string
+	}{
+		{"fail_if_ssl over TLS", false, func(h *conf.HTTPProbe) { h.FailIfSSL = true }, false, "ssl"},
+		{"fail_if_ssl over plain HTTP", true, func(h *conf.HTTPProbe) { h.FailIfSSL = true }, true, ""},
+		{"fail_if_not_ssl over plain HTTP", true, func(h *conf.HTTPProbe) { h.FailIfNotSSL = true }, false, "not_ssl"},
+		{"fail_if_not_ssl over TLS", false, func(h *conf.HTTPProbe) { h.FailIfNotSSL = true }, true, ""},
+		{"tls version below minimum", false, func(h *conf.HTTPProbe) { h.FailIfTLSVersionBelow = conf.TLSVersion(tls.VersionTLS13) }, false, "tls_version"},
+		{"tls version satisfies minimum", false, func(h *conf.HTTPProbe) { h.FailIfTLSVersionBelow = conf.TLSVersion(tls.VersionTLS12) }, true, ""},
+		{"cipher suite not allowed", false, func(h *conf.HTTPProbe) {
+			h.AllowedCipherSuites = []conf.TLSCipherSuite{conf.TLSCipherSuite(tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384)}
+		}, false, "cipher_suite"},
+		{"cipher suite allowed", false, func(h *conf.HTTPProbe) {
+			h.AllowedCipherSuites = []conf.TLSCipherSuite{conf.TLSCipherSuite(tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256)}
+		}, true, ""},
+		{"alpn protocol mismatch", false, func(h *conf.HTTPProbe) { h.RequiredALPNProtocol = "h2" }, false, "alpn_protocol"},
+		{"alpn protocol matches", false, func(h *conf.HTTPProbe) { h.RequiredALPNProtocol = "http/1.1" }, true, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			if test.plainHTTP {
+				ts.Start()
+			} else {
+				ts.TLS = &tls.Config{
+					MaxVersion:   tls.VersionTLS12,
+					CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+					NextProtos:   []string{"http/1.1"},
+				}
+				ts.StartTLS()
+			}
+			defer ts.Close()
+
+			httpProbe := conf.NewDefaultHTTPProbe()
+			httpProbe.HTTPClientConfig.TLSConfig.InsecureSkipVerify = true
+			test.configure(httpProbe)
+
+			registry := prometheus.NewRegistry()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			if result := ProbeHTTP(ctx, ts.URL, newTestModule(httpProbe), registry); result != test.expectedResult {
+				t.Fatalf("TLS policy test failed unexpectedly, got %t, want %t", result, test.expectedResult)
+			}
+			if test.violation != "" {
+				checkRegistryLabelResult(t, registry, "probe_tls_policy_violation", map[string]string{"reason": test.violation}, 1)
+			}
+		})
+	}
+}
+
+// checkRegistryLabelResult 校验registry中带label的metric取值
+func checkRegistryLabelResult(t *testing.T, registry *prometheus.Registry, name string, labels map[string]string, expected float64) {
+	t.Helper()
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.Metric {
+			matched := 0
+			for _, lp := range m.Label {
+				if v, ok := labels[lp.GetName()]; ok && v == lp.GetValue() {
+					matched++
+				}
+			}
+			if matched != len(labels) {
+				continue
+			}
+			if got := m.GetGauge().GetValue(); got != expected {
+				t.Fatalf("Expected %s%v to be %v, got %v", name, labels, expected, got)
+			}
+			return
+		}
+	}
+	t.Fatalf("Expected metric %s%v not found", name, labels)
+}
+
+func TestValidHTTPVersions(t *testing.T) {
+	tests := []struct {
+		versions       []string
+		expectedResult bool
+	}{
+		{[]string{"HTTP/1.1"}, true},
+		{[]string{"HTTP/1.1", "HTTP/2.0"}, true},
+		{[]string{"HTTP/2.0"}, false},
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	for _, test := range tests {
+		httpProbe := conf.NewDefaultHTTPProbe()
+		httpProbe.ValidHTTPVersions = test.versions
+
+		registry := prometheus.NewRegistry()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		result := ProbeHTTP(ctx, ts.URL, newTestModule(httpProbe), registry)
+		cancel()
+		if result != test.expectedResult {
+			t.Fatalf("Valid HTTP versions %v failed unexpectedly, got %t, want %t", test.versions, result, test.expectedResult)
+		}
+	}
+}
+
+func TestForceHTTP2(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	})
+
+	t.Run("h2c prior knowledge", func(t *testing.T) {
+		ts := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+		defer ts.Close()
+
+		httpProbe := conf.NewDefaultHTTPProbe()
+		httpProbe.ForceHTTP2 = true
+		httpProbe.ValidHTTPVersions = []string{"HTTP/2.0"}
+		httpProbe.FailIfBodyNotMatchesRegexp = []conf.Regexp{*conf.MustNewRegexp("^HTTP/2.0$")}
+
+		registry := prometheus.NewRegistry()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		if !ProbeHTTP(ctx, ts.URL, newTestModule(httpProbe), registry) {
+			t.Fatal("Probe failed unexpectedly")
+		}
+		checkRegistryResults(t, registry, map[string]float64{"probe_http_version": 2, "probe_http_ssl": 0})
+		checkRegistryLabelResult(t, registry, "probe_http_protocol_info", map[string]string{"protocol": "HTTP/2.0"}, 1)
+	})
+
+	t.Run("h2 over TLS", func(t *testing.T) {
+		ts := httptest.NewUnstartedServer(handler)
+		ts.EnableHTTP2 = true
+		ts.StartTLS()
+		defer ts.Close()
+
+		httpProbe := conf.NewDefaultHTTPProbe()
+		httpProbe.ForceHTTP2 = true
+		httpProbe.HTTPClientConfig.TLSConfig.InsecureSkipVerify = true
+
+		registry := prometheus.NewRegistry()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		if !ProbeHTTP(ctx, ts.URL, newTestModule(httpProbe), registry) {
+			t.Fatal("Probe failed unexpectedly")
+		}
+		checkRegistryResults(t, registry, map[string]float64{"probe_http_version": 2, "probe_http_ssl": 1})
+		checkRegistryLabelResult(t, registry, "probe_tls_alpn_protocol_info", map[string]string{"protocol": "h2"}, 1)
+	})
+
+	t.Run("server without HTTP/2", func(t *testing.T) {
+		ts := httptest.NewTLSServer(handler)
+		defer ts.Close()
+
+		httpProbe := conf.NewDefaultHTTPProbe()
+		httpProbe.ForceHTTP2 = true
+		httpProbe.HTTPClientConfig.TLSConfig.InsecureSkipVerify = true
+
+		registry := prometheus.NewRegistry()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		if ProbeHTTP(ctx, ts.URL, newTestModule(httpProbe), registry) {
+			t.Fatal("Probe succeeded unexpectedly")
+		}
+	})
+}
+
+func TestValidStatusCode(t *testing.T) {
+	tests := []struct {
+		statusCode     int
+		rules          []string
+		expectedResult bool
+	}{
+		{200, nil, true},
+		{304, nil, false},
+		{404, nil, false},
+		{404, []string{"4xx"}, true},
+		{404, []string{"!404"}, false},
+		{503, []string{"200-399", "503"}, true},
+	}
+
+	for _, test := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.statusCode)
+		}))
+
+		httpProbe := conf.NewDefaultHTTPProbe()
+		for _, rule := range test.rules {
+			httpProbe.ValidStatusCode = append(httpProbe.ValidStatusCode, conf.MustNewStatusCodeMatcher(rule))
+		}
+
+		registry := prometheus.NewRegistry()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		result := ProbeHTTP(ctx, ts.URL, newTestModule(httpProbe), registry)
+		cancel()
+		ts.Close()
+		if result != test.expectedResult {
+			t.Fatalf("Status code %d with rules %v failed unexpectedly, got %t, want %t", test.statusCode, test.rules, result, test.expectedResult)
+		}
+	}
+}
+
+func TestValidRedirectStatusCode(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			http.Redirect(w, r, "/temporary", http.StatusMovedPermanently)
+		case "/temporary":
+			http.Redirect(w, r, "/final", http.StatusTemporaryRedirect)
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		rules          []string
+		expectedResult bool
+	}{
+		{nil, true},
+		{[]string{"3xx"}, true},
+		{[]string{"301"}, false},
+		{[]string{"!307"}, false},
+	}
+
+	for _, test := range tests {
+		httpProbe := conf.NewDefaultHTTPProbe()
+		for _, rule := range test.rules {
+			httpProbe.ValidRedirectStatusCode = append(httpProbe.ValidRedirectStatusCode, conf.MustNewStatusCodeMatcher(rule))
+		}
+
+		registry := prometheus.NewRegistry()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		result := ProbeHTTP(ctx, ts.URL, newTestModule(httpProbe), registry)
+		cancel()
+		if result != test.expectedResult {
+			t.Fatalf("Redirect status code rules %v failed unexpectedly, got %t, want %t", test.rules, result, test.expectedResult)
+		}
+	}
+}
+
+func TestRedirectHopMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			http.Redirect(w, r, "/temporary", http.StatusMovedPermanently)
+		case "/temporary":
+			http.Redirect(w, r, "/final", http.StatusFound)
+		}
+	}))
+	defer ts.Close()
+
+	registry := prometheus.NewRegistry()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if !ProbeHTTP(ctx, ts.URL, newTestModule(conf.NewDefaultHTTPProbe()), registry) {
+		t.Fatal("Redirect test failed unexpectedly")
+	}
+
+	host := strings.TrimPrefix(ts.URL, "http://")
+	checkRegistryLabelResult(t, registry, "probe_http_redirect_hop_status_code", map[string]string{"hop": "0", "host": host}, 301)
+	checkRegistryLabelResult(t, registry, "probe_http_redirect_hop_status_code", map[string]string{"hop": "1", "host": host}, 302)
+	checkRegistryLabelResult(t, registry, "probe_http_redirect_hop_status_code", map[string]string{"hop": "2", "host": host}, 200)
+
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hops := map[string]bool{}
+	for _, mf := range mfs {
+		if mf.GetName() != "probe_http_redirect_hop_duration_seconds" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "hop" {
+					hops[label.GetValue()] = true
+				}
+			}
+		}
+	}
+	if len(hops) != 3 {
+		t.Fatalf("Expected durations for 3 hops, got %v", hops)
+	}
+}
+
+func TestMaxRedirects(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			http.Redirect(w, r, "/temporary", http.StatusFound)
+		case "/temporary":
+			http.Redirect(w, r, "/final", http.StatusFound)
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		maxRedirects   int
+		expectedResult bool
+	}{
+		{0, false},
+		{1, false},
+		{2, true},
+		{10, true},
+	}
+
+	for _, test := range tests {
+		httpProbe := conf.NewDefaultHTTPProbe()
+		httpProbe.MaxRedirects = test.maxRedirects
+
+		registry := prometheus.NewRegistry()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		result := ProbeHTTP(ctx, ts.URL, newTestModule(httpProbe), registry)
+		cancel()
+		if result != test.expectedResult {
+			t.Fatalf("max_redirects %d failed unexpectedly, got %t, want %t", test.maxRedirects, result, test.expectedResult)
+		}
+	}
+}
+
+func TestFailIfFinalURLNotMatchesRegexp(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/final", http.StatusFound)
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		regexp         string
+		expectedResult bool
+	}{
+		{"/final$", true},
+		{"^https://", false},
+	}
+
+	for _, test := range tests {
+		httpProbe := conf.NewDefaultHTTPProbe()
+		httpProbe.FailIfFinalURLNotMatchesRegexp = []conf.Regexp{*conf.MustNewRegexp(test.regexp)}
+
+		registry := prometheus.NewRegistry()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		result := ProbeHTTP(ctx, ts.URL, newTestModule(httpProbe), registry)
+		cancel()
+		if result != test.expectedResult {
+			t.Fatalf("Final URL regexp %q failed unexpectedly, got %t, want %t", test.regexp, result, test.expectedResult)
+		}
+		checkFailedDueToRegex(t, registry, !test.expectedResult)
+	}
+}
+
+func TestFailIfRedirectLocationNotMatchesRegexp(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/final", http.StatusFound)
+		}
+	}))
+	defer ts.Close()
+	// 相对地址的跳转，请求实际发往解析后的ip，匹配时仍使用原始的host
+	localhostURL := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+
+	tests := []struct {
+		target         string
+		regexp         string
+		expectedResult bool
+	}{
+		{ts.URL, "^http://.*/final$", true},
+		{ts.URL, "^https://", false},
+		{localhostURL, "^http://localhost:", true},
+		{localhostURL, `^http://127\.0\.0\.1:`, false},
+	}
+
+	for _, test := range tests {
+		httpProbe := conf.NewDefaultHTTPProbe()
+		httpProbe.IPProtocol = "ip4"
+		httpProbe.FailIfRedirectLocationNotMatchesRegexp = []conf.Regexp{*conf.MustNewRegexp(test.regexp)}
+
+		registry := prometheus.NewRegistry()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		result := ProbeHTTP(ctx, test.target, newTestModule(httpProbe), registry)
+		cancel()
+		if result != test.expectedResult {
+			t.Fatalf("Redirect location regexp %q failed unexpectedly, got %t, want %t", test.regexp, result, test.expectedResult)
+		}
+		checkFailedDueToRegex(t, registry, !test.expectedResult)
+	}
+}
+
+func TestFailureReason(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/notfound":
+			w.WriteHeader(http.StatusNotFound)
+		case "/redirect":
+			http.Redirect(w, r, "/", http.StatusMovedPermanently)
+		case "/slow":
+			time.Sleep(500 * time.Millisecond)
+		default:
+			w.Header().Set("X-Status", "failed")
+			w.Write([]byte("request failed"))
+		}
+	}))
+	defer ts.Close()
+
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsServer.Close()
+
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+
+	tests := []struct {
+		name           string
+		target         string
+		configure      func(httpProbe *conf.HTTPProbe)
+		expectedReason utils.FailureReason
+	}{
+		{"success", ts.URL, func(httpProbe *conf.HTTPProbe) {}, ""},
+		{"status code", ts.URL + "/notfound", func(httpProbe *conf.HTTPProbe) {}, utils.FailureStatusCode},
+		{"redirect", ts.URL + "/redirect", func(httpProbe *conf.HTTPProbe) {
+			httpProbe.ValidRedirectStatusCode = conf.StatusCodeMatchers{conf.MustNewStatusCodeMatcher("302")}
+		}, utils.FailureRedirect},
+		{"body regexp", ts.URL, func(httpProbe *conf.HTTPProbe) {
+			httpProbe.FailIfBodyMatchesRegexp = []conf.Regexp{*conf.MustNewRegexp("failed")}
+		}, utils.FailureRegexBody},
+		{"header regexp", ts.URL, func(httpProbe *conf.HTTPProbe) {
+			httpProbe.FailIfHeaderMatchesRegexp = []conf.HeaderMatch{{Header: "X-Status", Regexp: *conf.MustNewRegexp("failed")}}
+		}, utils.FailureRegexHeader},
+		{"body too large", ts.URL, func(httpProbe *conf.HTTPProbe) {
+			httpProbe.BodySizeLimit = 4
+		}, utils.FailureBodyTooLarge},
+		{"timeout", ts.URL + "/slow", func(httpProbe *conf.HTTPProbe) {}, utils.FailureTimeout},
+		{"connect refused", closed.URL, func(httpProbe *conf.HTTPProbe) {}, utils.FailureConnectRefused},
+		{"cert invalid", tlsServer.URL, func(httpProbe *conf.HTTPProbe) {}, utils.FailureCertInvalid},
+		{"tls policy", tlsServer.URL, func(httpProbe *conf.HTTPProbe) {
+			httpProbe.HTTPClientConfig.TLSConfig.InsecureSkipVerify = true
+			httpProbe.FailIfSSL = true
+		}, utils.FailureTLSPolicy},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			httpProbe := conf.NewDefaultHTTPProbe()
+			test.configure(httpProbe)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			ctx, failureReason := utils.NewFailureContext(ctx)
+			result := ProbeHTTP(ctx, test.target, newTestModule(httpProbe), prometheus.NewRegistry())
+			if result != (test.expectedReason == "") {
+				t.Fatalf("Unexpected probe result %t", result)
+			}
+			if reason := failureReason(); reason != test.expectedReason {
+				t.Fatalf("Expected failure reason %q, got %q", test.expectedReason, reason)
+			}
+		})
+	}
+}
+
+func TestSSLVerification(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name                  string
+		configure             func(tlsConfig *pconfig.TLSConfig)
+		expectedResult        bool
+		expectedVerified      float64
+		expectedHostnameMatch float64
+		expectedErrorReason   string
+	}{
+		{"insecure_skip_verify", func(c *pconfig.TLSConfig) { c.InsecureSkipVerify = true }, true, 0, 1, "unknown_authority"},
+		// 握手失败时仍然导出校验结果
+		{"handshake failed", func(c *pconfig.TLSConfig) {}, false, 0, 1, "unknown_authority"},
+		{"ca_file", func(c *pconfig.TLSConfig) { c.CAFile = caFile }, true, 1, 1, ""},
+		{"hostname mismatch", func(c *pconfig.TLSConfig) {
+			c.CAFile = caFile
+			c.ServerName = "example.org"
+			c.InsecureSkipVerify = true
+		}, true, 1, 0, "hostname_mismatch"},
+	}
+
+	for _, test := range tests {
+		httpProbe := conf.NewDefaultHTTPProbe()
+		test.configure(&httpProbe.HTTPClientConfig.TLSConfig)
+
+		registry := prometheus.NewRegistry()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		result := ProbeHTTP(ctx, ts.URL, newTestModule(httpProbe), registry)
+		cancel()
+		if result != test.expectedResult {
+			t.Fatalf("%s: unexpected result %t", test.name, result)
+		}
+		checkRegistryResults(t, registry, map[string]float64{
+			"probe_ssl_verified":       test.expectedVerified,
+			"probe_ssl_hostname_match": test.expectedHostnameMatch,
+		})
+		if test.expectedErrorReason != "" {
+			checkRegistryLabelResult(t, registry, "probe_ssl_verify_error_info", map[string]string{"reason": test.expectedErrorReason}, 1)
+		}
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/notfound" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, "hello world")
+	}))
+	defer ts.Close()
+	// 使用域名作为target，每个解析出的地址单独探测
+	target := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+
+	tests := []struct {
+		name           string
+		path           string
+		policy         string
+		expectedReason utils.FailureReason
+	}{
+		{"all", "/", conf.FanOutPolicyAll, ""},
+		{"default policy", "/", "", ""},
+		{"any failed", "/notfound", conf.FanOutPolicyAny, utils.FailureStatusCode},
+		{"quorum failed", "/notfound", conf.FanOutPolicyQuorum, utils.FailureStatusCode},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			httpProbe := conf.NewDefaultHTTPProbe()
+			httpProbe.FanOut = conf.FanOutConfig{Enabled: true, SuccessPolicy: test.policy}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			ctx, failureReason := utils.NewFailureContext(ctx)
+			registry := prometheus.NewRegistry()
+			result := ProbeHTTP(ctx, target+test.path, newTestModule(httpProbe), registry)
+			if result != (test.expectedReason == "") {
+				t.Fatalf("Unexpected probe result %t", result)
+			}
+			if reason := failureReason(); reason != test.expectedReason {
+				t.Fatalf("Expected failure reason %q, got %q", test.expectedReason, reason)
+			}
+
+			succeeded := 1.0
+			if !result {
+				succeeded = 0
+				checkRegistryLabelResult(t, registry, "probe_http_ip_failure_reason", map[string]string{"ip": "127.0.0.1", "reason": string(test.expectedReason)}, 1)
+			}
+			checkRegistryResults(t, registry, map[string]float64{
+				"probe_http_fan_out_ips":           1,
+				"probe_http_fan_out_ips_succeeded": succeeded,
+				"probe_ip_protocol":                4,
+			})
+			checkRegistryLabelResult(t, registry, "probe_http_ip_success", map[string]string{"ip": "127.0.0.1"}, succeeded)
+			// 单个地址的metrics都带有ip label
+			checkRegistryLabelResult(t, registry, "probe_http_status_code", map[string]string{"ip": "127.0.0.1"}, map[bool]float64{true: 200, false: 404}[result])
+		})
+	}
+}
+
+// checkDurationsNotNegative 校验总耗时和每一跳的耗时都不是负数，返回每一跳的耗时
+func checkDurationsNotNegative(t *testing.T, registry *prometheus.Registry) map[string]float64 {
+	t.Helper()
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hops := map[string]float64{}
+	for _, mf := range mfs {
+		if mf.GetName() != "probe_http_duration_seconds" && mf.GetName() != "probe_http_redirect_hop_duration_seconds" {
+			continue
+		}
+		for _, m := range mf.Metric {
+			name := mf.GetName()
+			for _, lp := range m.Label {
+				name += "{" + lp.GetValue() + "}"
+			}
+			if v := m.GetGauge().GetValue(); v < 0 || v > 1 {
+				t.Errorf("Unexpected %s: %v", name, v)
+			}
+			hops[name] = m.GetGauge().GetValue()
+		}
+	}
+	return hops
+}
+
+func TestPhaseDurationsConnectionRefused(t *testing.T) {
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+
+	for _, target := range []string{closed.URL, strings.Replace(closed.URL, "http://", "https://", 1)} {
+		registry := prometheus.NewRegistry()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if ProbeHTTP(ctx, target, newTestModule(conf.NewDefaultHTTPProbe()), registry) {
+			t.Fatalf("%s: probe succeeded unexpectedly", target)
+		}
+		cancel()
+		durations := checkDurationsNotNegative(t, registry)
+		// 连接失败之前的耗时仍然记录在connect阶段，之后的阶段都没有发生
+		for _, phase := range []string{"tls", "processing", "transfer"} {
+			if d, ok := durations["probe_http_redirect_hop_duration_seconds{0}{"+phase+"}"]; ok {
+				t.Errorf("%s: expected no %s duration for a refused connection, got %v", target, phase, d)
+			}
+		}
+	}
+}
+
+func TestPhaseDurationsSameHostRedirect(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/final", http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, "hello world")
+	})
+
+	tests := []struct {
+		name       string
+		server     *httptest.Server
+		forceHTTP2 bool
+	}{
+		{"http/1.1", httptest.NewServer(handler), false},
+		// HTTP/2 重定向时复用同一个连接
+		{"h2c", httptest.NewServer(h2c.NewHandler(handler, &http2.Server{})), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer test.server.Close()
+			httpProbe := conf.NewDefaultHTTPProbe()
+			httpProbe.ForceHTTP2 = test.forceHTTP2
+			// 使用域名使重定向的一跳也需要解析
+			target := strings.Replace(test.server.URL, "127.0.0.1", "localhost", 1)
+
+			registry := prometheus.NewRegistry()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if !ProbeHTTP(ctx, target, newTestModule(httpProbe), registry) {
+				t.Fatal("Probe failed unexpectedly")
+			}
+			checkRegistryResults(t, registry, map[string]float64{"probe_http_redirects": 1})
+			durations := checkDurationsNotNegative(t, registry)
+			if _, ok := durations["probe_http_redirect_hop_duration_seconds{1}{connect}"]; !ok {
+				t.Errorf("connect duration of the redirect hop not recorded: %v", durations)
+			}
+			if test.forceHTTP2 && durations["probe_http_redirect_hop_duration_seconds{1}{connect}"] != 0 {
+				t.Errorf("Expected reused connection to have no connect duration: %v", durations)
+			}
+		})
+	}
+}
+
+func TestCertificatePins(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	spki := sha256.Sum256(ts.Certificate().RawSubjectPublicKeyInfo)
+	other := sha256.Sum256([]byte("other"))
+
+	tests := []struct {
+		name           string
+		pins           conf.PinConfig
+		expectedResult bool
+		expectedReason utils.FailureReason
+	}{
+		{"matching pin", conf.PinConfig{SPKISHA256: []string{hex.EncodeToString(other[:]), "sha256/" + base64.StdEncoding.EncodeToString(spki[:])}}, true, ""},
+		{"non-matching pin", conf.PinConfig{SPKISHA256: []string{hex.EncodeToString(other[:])}}, false, utils.FailurePinMismatch},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			httpProbe := conf.NewDefaultHTTPProbe()
+			httpProbe.HTTPClientConfig.TLSConfig.InsecureSkipVerify = true
+			httpProbe.CertificatePins = test.pins
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			ctx, failureReason := utils.NewFailureContext(ctx)
+			registry := prometheus.NewRegistry()
+			result := ProbeHTTP(ctx, ts.URL, newTestModule(httpProbe), registry)
+			if result != test.expectedResult {
+				t.Fatalf("Unexpected probe result %t", result)
+			}
+			if reason := failureReason(); reason != test.expectedReason {
+				t.Fatalf("Expected failure reason %q, got %q", test.expectedReason, reason)
+			}
+			expectedMatched := 0.0
+			if test.expectedResult {
+				expectedMatched = 1
+			}
+			checkRegistryResults(t, registry, map[string]float64{"probe_ssl_pin_matched": expectedMatched})
+		})
+	}
+}
+
+// newFanOutResolver 启动本地dns服务器，所有A记录查询都返回ips，返回使用该服务器的resolver
+func newFanOutResolver(t *testing.T, ips ...string) *net.Resolver {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := &dns.Msg{}
+		m.SetReply(r)
+		if q := r.Question[0]; q.Qtype == dns.TypeA {
+			for _, ip := range ips {
+				rr, _ := dns.NewRR(fmt.Sprintf("%s 60 IN A %s", q.Name, ip))
+				m.Answer = append(m.Answer, rr)
+			}
+		}
+		w.WriteMsg(m)
+	})}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+
+	return &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "udp", pc.LocalAddr().String())
+	}}
+}
+
+func TestFanOutPolicies(t *testing.T) {
+	// 三个回环地址监听同一个端口：127.0.0.1 总是成功，127.0.0.2 只有 /two 成功，127.0.0.3 总是失败
+	handlers := map[string]http.HandlerFunc{
+		"127.0.0.1": func(w http.ResponseWriter, r *http.Request) {},
+		"127.0.0.2": func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/two" {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		},
+		"127.0.0.3": func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) },
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	for _, ip := range []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"} {
+		if ip != "127.0.0.1" {
+			if ln, err = net.Listen("tcp", net.JoinHostPort(ip, port)); err != nil {
+				t.Skipf("Unable to listen on %s: %s", ip, err)
+			}
+		}
+		ts := httptest.NewUnstartedServer(handlers[ip])
+		ts.Listener = ln
+		ts.Start()
+		defer ts.Close()
+	}
+	resolver := newFanOutResolver(t, "127.0.0.1", "127.0.0.2", "127.0.0.3")
+
+	tests := []struct {
+		name            string
+		path            string
+		policy          string
+		expectedResult  bool
+		expectedSuccess map[string]float64
+	}{
+		{"all", "/two", conf.FanOutPolicyAll, false, map[string]float64{"127.0.0.1": 1, "127.0.0.2": 1, "127.0.0.3": 0}},
+		{"any", "/one", conf.FanOutPolicyAny, true, map[string]float64{"127.0.0.1": 1, "127.0.0.2": 0, "127.0.0.3": 0}},
+		{"quorum failed", "/one", conf.FanOutPolicyQuorum, false, map[string]float64{"127.0.0.1": 1, "127.0.0.2": 0, "127.0.0.3": 0}},
+		{"quorum", "/two", conf.FanOutPolicyQuorum, true, map[string]float64{"127.0.0.1": 1, "127.0.0.2": 1, "127.0.0.3": 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			httpProbe := conf.NewDefaultHTTPProbe()
+			httpProbe.IPProtocol = "ip4"
+			httpProbe.FanOut = conf.FanOutConfig{Enabled: true, SuccessPolicy: test.policy}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			ctx, failureReason := utils.NewFailureContext(conf.WithResolver(ctx, resolver))
+			registry := prometheus.NewRegistry()
+			result := ProbeHTTP(ctx, "http://fanout.test:"+port+test.path, newTestModule(httpProbe), registry)
+			if result != test.expectedResult {
+				t.Fatalf("Unexpected probe result %t", result)
+			}
+			expectedReason := utils.FailureStatusCode
+			if result {
+				expectedReason = ""
+			}
+			if reason := failureReason(); reason != expectedReason {
+				t.Fatalf("Expected failure reason %q, got %q", expectedReason, reason)
+			}
+
+			succeeded := 0.0
+			for ip, success := range test.expectedSuccess {
+				succeeded += success
+				checkRegistryLabelResult(t, registry, "probe_http_ip_success", map[string]string{"ip": ip}, success)
+				if success == 0 {
+					checkRegistryLabelResult(t, registry, "probe_http_ip_failure_reason", map[string]string{"ip": ip, "reason": string(utils.FailureStatusCode)}, 1)
+				}
+			}
+			checkRegistryResults(t, registry, map[string]float64{
+				"probe_http_fan_out_ips":           3,
+				"probe_http_fan_out_ips_succeeded": succeeded,
+			})
+		})
+	}
+}
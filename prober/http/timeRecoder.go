@@ -52,11 +52,12 @@ func (t *transport) ConnectDone(net, addr string, err error) {
 	t.current.connectDone = time.Now()
 }
 
-func (t *transport) GotConn(_ httptrace.GotConnInfo) {
+func (t *transport) GotConn(info httptrace.GotConnInfo) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
 	t.current.gotDone = time.Now()
+	t.current.reused = info.Reused
 }
 
 func (t *transport) GotFirstResponseByte() {
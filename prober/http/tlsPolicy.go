@@ -0,0 +1,87 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/utils"
+	"go.uber.org/zap"
+)
+
+// tls策略违规的原因，作为 probe_tls_policy_violation 的 reason label
+const (
+	tlsPolicySSL         = "ssl"
+	tlsPolicyNotSSL      = "not_ssl"
+	tlsPolicyVersion     = "tls_version"
+	tlsPolicyCipherSuite = "cipher_suite"
+	tlsPolicyALPN        = "alpn_protocol"
+)
+
+// checkTLSPolicy 根据最后一跳的TLS连接状态校验模块配置的TLS策略
+// 每条配置了的策略都会在 violationGaugeVec 中产生一个序列，违反为1，否则为0
+func checkTLSPolicy(ctx context.Context, state *tls.ConnectionState, httpConfig conf.HTTPProbe, violationGaugeVec *prometheus.GaugeVec) (success bool) {
+	l := logger(ctx)
+	success = true
+	violate := func(reason string, violated bool) {
+		if violated {
+			violationGaugeVec.WithLabelValues(reason).Set(1)
+			success = false
+		} else {
+			violationGaugeVec.WithLabelValues(reason).Set(0)
+		}
+	}
+
+	if httpConfig.FailIfSSL {
+		if state != nil {
+			l.Error("Final request was over SSL")
+		}
+		violate(tlsPolicySSL, state != nil)
+	}
+	if httpConfig.FailIfNotSSL {
+		if state == nil {
+			l.Error("Final request was not over SSL")
+		}
+		violate(tlsPolicyNotSSL, state == nil)
+	}
+
+	// 以下策略只针对TLS连接，是否必须使用TLS由 fail_if_not_ssl 决定
+	if state == nil {
+		return
+	}
+
+	if httpConfig.FailIfTLSVersionBelow != 0 {
+		violated := state.Version < uint16(httpConfig.FailIfTLSVersionBelow)
+		if violated {
+			l.Error("TLS version is below the minimum",
+				zap.String("version", utils.GetTLSVersion(state)),
+				zap.String("minimum", httpConfig.FailIfTLSVersionBelow.String()))
+		}
+		violate(tlsPolicyVersion, violated)
+	}
+
+	if len(httpConfig.AllowedCipherSuites) > 0 {
+		violated := true
+		for _, suite := range httpConfig.AllowedCipherSuites {
+			if uint16(suite) == state.CipherSuite {
+				violated = false
+				break
+			}
+		}
+		if violated {
+			l.Error("Cipher suite is not allowed", zap.String("cipher_suite", tls.CipherSuiteName(state.CipherSuite)))
+		}
+		violate(tlsPolicyCipherSuite, violated)
+	}
+
+	if httpConfig.RequiredALPNProtocol != "" {
+		violated := state.NegotiatedProtocol != httpConfig.RequiredALPNProtocol
+		if violated {
+			l.Error("Negotiated ALPN protocol does not match",
+				zap.String("negotiated_protocol", state.NegotiatedProtocol),
+				zap.String("required_protocol", httpConfig.RequiredALPNProtocol))
+		}
+		violate(tlsPolicyALPN, violated)
+	}
+	return
+}
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"context"
 	"fmt"
 	"github.com/prometheus/common/version"
 	"github.com/yuanyp8/http_exporter/utils"
@@ -10,7 +11,11 @@ import (
 	"time"
 )
 
-var l = utils.Logger.Named("HTTP")
+// logger 返回本次探测使用的logger，debug时日志会同时被记录下来
+func logger(ctx context.Context) *zap.Logger {
+	return utils.LoggerFromContext(ctx, "HTTP")
+}
+
 var userAgentDefaultHeader = fmt.Sprintf("Blackbox Exporter/%s", version.Version)
 
 // NewRequest -> client.Do -> transport.Transport
@@ -35,6 +40,14 @@ func newTransport(rt, noServerName http.RoundTripper) *transport {
 // 记录一次http监测的生命周期
 type roundTripTrace struct {
 	tls           bool
+	reused        bool   // 复用了已有的连接，没有解析和建立连接的过程
+	noServerName  bool   // 重定向到其它地址，没有使用配置的 server_name
+	url           string // 本跳请求的URL，host为请求的Host而不是解析后的ip
+	host          string
+	statusCode    int
+	proto         string
+	reqHeader     http.Header
+	respHeader    http.Header
 	start         time.Time
 	dnsDone       time.Time
 	connectDone   time.Time
@@ -45,27 +58,61 @@ type roundTripTrace struct {
 	tlsDone       time.Time
 }
 
+// requestHost 返回请求的Host，连接已解析的ip时URL中的host为ip，Host才是原始的host
+func requestHost(req *http.Request) string {
+	if req.Host != "" {
+		return req.Host
+	}
+	return req.URL.Host
+}
+
+// requestURL 返回用原始host替换ip后的请求URL
+func requestURL(req *http.Request) string {
+	u := *req.URL
+	u.Host = requestHost(req)
+	return u.String()
+}
+
 // RoundTrip 对http client RoundTrip的一层封装
 // 实现RoundTripper接口
 func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	l := logger(req.Context())
 	l.Info("Making HTTP request", zap.String("url", req.URL.String()), zap.String("host", req.Host))
 
-	t.current = &roundTripTrace{}
+	host := requestHost(req)
+
+	t.mu.Lock()
+	t.current = &roundTripTrace{url: requestURL(req), host: host, reqHeader: req.Header.Clone()}
 	if req.URL.Scheme == "https" {
 		t.current.tls = true
 	}
 	t.traces = append(t.traces, t.current)
+	current := t.current
 
 	if t.firstHost == "" {
 		t.firstHost = req.URL.Host
 	}
+	t.mu.Unlock()
 
+	rt := t.Transport
 	// redirect
 	if t.firstHost != req.URL.Host {
 		// 发生了重定向
 		l.Info("Address does not match first address, not sending TLS ServerName", zap.String("first", t.firstHost), zap.String("address", req.URL.Host))
 		// RoundTrip可以理解为自带的连接池管理功能，支持连接重用
-		return t.NoServerNameTransport.RoundTrip(req)
+		rt = t.NoServerNameTransport
+		t.mu.Lock()
+		current.noServerName = true
+		t.mu.Unlock()
+	}
+
+	resp, err := rt.RoundTrip(req)
+	if resp != nil {
+		t.mu.Lock()
+		current.statusCode = resp.StatusCode
+		current.proto = resp.Proto
+		current.respHeader = resp.Header.Clone()
+		t.mu.Unlock()
 	}
-	return t.Transport.RoundTrip(req)
+	return resp, err
 }
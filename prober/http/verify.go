@@ -0,0 +1,62 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+	"github.com/yuanyp8/http_exporter/utils"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// presentedCertificates 返回最后一跳服务端发送的证书链
+// 握手因证书校验失败时请求没有响应，从错误中取出未通过校验的证书链
+func presentedCertificates(resp *http.Response, err error) []*x509.Certificate {
+	if resp != nil && resp.TLS != nil {
+		return resp.TLS.PeerCertificates
+	}
+	var verifyErr *tls.CertificateVerificationError
+	if errors.As(err, &verifyErr) {
+		return verifyErr.UnverifiedCertificates
+	}
+	return nil
+}
+
+// verifyPresentedChain 使用 tls_config 中的ca和期望的hostname独立校验证书链
+// 不受 insecure_skip_verify 影响，也不影响探测结果，只用于导出校验结果
+func verifyPresentedChain(ctx context.Context, certs []*x509.Certificate, tlsConfig pconfig.TLSConfig, hostname string,
+	verifiedGauge, hostnameMatchGauge prometheus.Gauge, verifyErrorGaugeVec *prometheus.GaugeVec) {
+	l := logger(ctx)
+
+	// 只需要根据ca_file等配置生成的RootCAs，未配置ca时为nil即系统根证书
+	tlsConfig.InsecureSkipVerify = false
+	cfg, err := pconfig.NewTLSConfig(&tlsConfig)
+	if err != nil {
+		l.Error("Error creating TLS configuration for verification", zap.Error(err))
+		return
+	}
+
+	var reason string
+	if _, err := utils.VerifyCertificates(certs, cfg.RootCAs, ""); err != nil {
+		reason = utils.VerifyErrorReason(err)
+		l.Info("Presented certificate chain did not verify", zap.String("reason", reason), zap.Error(err))
+	} else {
+		verifiedGauge.Set(1)
+	}
+
+	if err := certs[0].VerifyHostname(hostname); err != nil {
+		if reason == "" {
+			reason = utils.VerifyErrorReason(err)
+		}
+		l.Info("Leaf certificate does not match hostname", zap.String("hostname", hostname), zap.Error(err))
+	} else {
+		hostnameMatchGauge.Set(1)
+	}
+
+	if reason != "" {
+		verifyErrorGaugeVec.WithLabelValues(reason).Set(1)
+	}
+}
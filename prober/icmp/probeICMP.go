@@ -0,0 +1,188 @@
+package icmp
+
+import (
+	"bytes"
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/utils"
+	"go.uber.org/zap"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	icmpID            = rand.New(rand.NewSource(time.Now().UnixNano())).Intn(1 << 16)
+	icmpSequence      uint16
+	icmpSequenceMutex sync.Mutex
+)
+
+// 未配置 payload_size 时echo request中携带的数据
+var defaultPayload = []byte("Prometheus HTTP Exporter")
+
+// logger 返回本次探测使用的logger
+func logger(ctx context.Context) *zap.Logger {
+	return utils.LoggerFromContext(ctx, "ICMP")
+}
+
+// nextSequence 并发探测时每个echo request使用不同的序号，用来匹配对应的reply
+func nextSequence() int {
+	icmpSequenceMutex.Lock()
+	defer icmpSequenceMutex.Unlock()
+	icmpSequence++
+	return int(icmpSequence)
+}
+
+// newPayload 生成指定长度的数据
+func newPayload(size int) []byte {
+	if size == 0 {
+		return defaultPayload
+	}
+	payload := make([]byte, size)
+	for i := range payload {
+		payload[i] = byte(i % 256)
+	}
+	return payload
+}
+
+// ProbeICMP 发送一个echo request并等待对应的echo reply
+// 优先使用不需要权限的 SOCK_DGRAM icmp socket（Linux 需要 net.ipv4.ping_group_range 包含当前用户组），失败时回退到raw socket
+func ProbeICMP(ctx context.Context, target string, module conf.Module, registry *prometheus.Registry) bool {
+	var (
+		durationGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_icmp_duration_seconds",
+			Help: "Duration of icmp request by phase",
+		}, []string{"phase"})
+	)
+
+	for _, lv := range []string{"resolve", "setup", "rtt"} {
+		durationGaugeVec.WithLabelValues(lv)
+	}
+	registry.MustRegister(durationGaugeVec)
+
+	l := logger(ctx)
+	icmpConfig := *module.ICMP
+
+	ip, lookupTime, err := conf.ChooseProtocol(ctx, icmpConfig.IPProtocol, icmpConfig.IPProtocolFallback, target, registry)
+	if err != nil {
+		l.Error("Error resolving address", zap.Error(err))
+		return false
+	}
+	durationGaugeVec.WithLabelValues("resolve").Add(lookupTime)
+
+	v4 := ip.IP.To4() != nil
+	srcIP := net.IPv6unspecified
+	if v4 {
+		srcIP = net.IPv4zero
+	}
+	if icmpConfig.SourceIPAddress != "" {
+		srcIP = net.ParseIP(icmpConfig.SourceIPAddress)
+	}
+
+	setupStart := time.Now()
+	l.Info("Creating socket")
+	conn, privileged, err := listen(ctx, v4, srcIP, icmpConfig.DontFragment)
+	if err != nil {
+		l.Error("Error listening to socket", zap.Error(err))
+		utils.RecordFailure(ctx, utils.FailureSocket)
+		return false
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			l.Error("Error setting socket deadline", zap.Error(err))
+			utils.RecordFailure(ctx, utils.FailureSocket)
+			return false
+		}
+	}
+
+	var (
+		requestType icmp.Type = ipv6.ICMPTypeEchoRequest
+		replyType   icmp.Type = ipv6.ICMPTypeEchoReply
+		protocol              = 58 // ipv6-icmp
+	)
+	if v4 {
+		requestType, replyType, protocol = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply, 1
+	}
+
+	seq := nextSequence()
+	payload := newPayload(icmpConfig.PayloadSize)
+	wm := icmp.Message{
+		Type: requestType,
+		Code: 0,
+		Body: &icmp.Echo{ID: icmpID, Seq: seq, Data: payload},
+	}
+	wb, err := wm.Marshal(nil)
+	if err != nil {
+		l.Error("Error marshalling packet", zap.Error(err))
+		utils.RecordFailure(ctx, utils.FailureSocket)
+		return false
+	}
+
+	// SOCK_DGRAM 使用UDPAddr作为目标地址
+	var dst net.Addr = ip
+	if !privileged {
+		dst = &net.UDPAddr{IP: ip.IP, Zone: ip.Zone}
+	}
+	durationGaugeVec.WithLabelValues("setup").Add(time.Since(setupStart).Seconds())
+
+	l.Info("Writing out packet", zap.String("ip", ip.String()), zap.Bool("privileged", privileged), zap.Int("seq", seq), zap.Int("payload_size", len(payload)))
+	rttStart := time.Now()
+	if err := conn.write(wb, dst, icmpConfig.TTL); err != nil {
+		reason := utils.ClassifyError(err)
+		if reason == utils.FailureUnknown {
+			reason = utils.FailureSocket
+		}
+		l.Error("Error writing to socket", zap.String("reason", string(reason)), zap.Error(err))
+		utils.RecordFailure(ctx, reason)
+		return false
+	}
+
+	rb := make([]byte, 65536)
+	l.Info("Waiting for reply packets")
+	for {
+		n, peer, err := conn.read(rb)
+		if err != nil {
+			reason := utils.ClassifyError(err)
+			if reason == utils.FailureUnknown {
+				reason = utils.FailureSocket
+			}
+			l.Error("Error reading from socket", zap.String("reason", string(reason)), zap.Error(err))
+			utils.RecordFailure(ctx, reason)
+			return false
+		}
+		if !peerMatches(peer, ip.IP) {
+			continue
+		}
+		rm, err := icmp.ParseMessage(protocol, rb[:n])
+		if err != nil || rm.Type != replyType {
+			continue
+		}
+		echo, ok := rm.Body.(*icmp.Echo)
+		// SOCK_DGRAM 的ID由内核分配，只能通过序号和数据匹配
+		if !ok || echo.Seq != seq || !bytes.Equal(echo.Data, payload) || (privileged && echo.ID != icmpID) {
+			continue
+		}
+		rtt := time.Since(rttStart)
+		durationGaugeVec.WithLabelValues("rtt").Add(rtt.Seconds())
+		l.Info("Found matching reply packet", zap.Duration("rtt", rtt))
+		return true
+	}
+}
+
+// peerMatches 判断reply是否来自target
+func peerMatches(peer net.Addr, ip net.IP) bool {
+	switch addr := peer.(type) {
+	case *net.IPAddr:
+		return addr.IP.Equal(ip)
+	case *net.UDPAddr:
+		return addr.IP.Equal(ip)
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package icmp
+
+import (
+	"context"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/prober/probetest"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestModule(icmpProbe *conf.ICMPProbe) conf.Module {
+	return conf.Module{
+		Prober:  "icmp",
+		Timeout: time.Second,
+		ICMP:    icmpProbe,
+	}
+}
+
+// requireSocket 当前环境既不允许 SOCK_DGRAM 也不允许 raw socket 时跳过测试
+func requireSocket(t *testing.T, dontFragment bool) {
+	t.Helper()
+	conn, _, err := listen(context.Background(), true, net.IPv4zero, dontFragment)
+	if err != nil {
+		t.Skipf("Unable to create icmp socket: %s", err)
+	}
+	conn.Close()
+}
+
+func TestICMPEcho(t *testing.T) {
+	tests := []struct {
+		name         string
+		dontFragment bool
+		configure    func(icmpProbe *conf.ICMPProbe)
+	}{
+		{"default", false, func(*conf.ICMPProbe) {}},
+		{"payload_size", false, func(i *conf.ICMPProbe) { i.PayloadSize = 1200 }},
+		{"ttl", false, func(i *conf.ICMPProbe) { i.TTL = 8 }},
+		{"dont_fragment", true, func(i *conf.ICMPProbe) {
+			i.IPProtocolFallback = false
+			i.DontFragment = true
+			i.PayloadSize = 1000
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requireSocket(t, test.dontFragment)
+			icmpProbe := conf.NewDefaultICMPProbe()
+			test.configure(icmpProbe)
+
+			result, reason, registry := probetest.Probe(t, ProbeICMP, "127.0.0.1", newTestModule(icmpProbe))
+			probetest.CheckResult(t, result, reason, true, "")
+			if rtt := probetest.Gather(t, registry)["probe_icmp_duration_seconds{rtt}"]; rtt <= 0 {
+				t.Errorf("probe_icmp_duration_seconds{phase=\"rtt\"} not recorded")
+			}
+		})
+	}
+}
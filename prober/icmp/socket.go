@@ -0,0 +1,111 @@
+package icmp
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"net"
+	"time"
+)
+
+// packetConn 屏蔽 SOCK_DGRAM、raw socket 以及需要自己构造ip头的raw socket之间的差异
+type packetConn interface {
+	write(b []byte, dst net.Addr, ttl int) error
+	read(b []byte) (int, net.Addr, error)
+	SetDeadline(t time.Time) error
+	Close() error
+}
+
+// listen 创建icmp socket，privileged 表示是否使用了raw socket
+// dont_fragment 需要自己构造ip头，只能使用raw socket
+func listen(ctx context.Context, v4 bool, src net.IP, dontFragment bool) (conn packetConn, privileged bool, err error) {
+	l := logger(ctx)
+
+	if dontFragment {
+		pc, err := net.ListenPacket("ip4:icmp", src.String())
+		if err != nil {
+			return nil, true, err
+		}
+		rc, err := ipv4.NewRawConn(pc)
+		if err != nil {
+			pc.Close()
+			return nil, true, err
+		}
+		return &rawConn{RawConn: rc, src: src}, true, nil
+	}
+
+	unprivileged, privilegedNetwork := "udp6", "ip6:ipv6-icmp"
+	if v4 {
+		unprivileged, privilegedNetwork = "udp4", "ip4:icmp"
+	}
+	c, err := icmp.ListenPacket(unprivileged, src.String())
+	if err == nil {
+		return &icmpConn{PacketConn: c, v4: v4}, false, nil
+	}
+	l.Debug("Unable to do unprivileged listen on socket, will attempt privileged", zap.Error(err))
+
+	c, err = icmp.ListenPacket(privilegedNetwork, src.String())
+	if err != nil {
+		return nil, true, err
+	}
+	return &icmpConn{PacketConn: c, v4: v4}, true, nil
+}
+
+// icmpConn SOCK_DGRAM 或 raw socket，ip头由内核构造
+type icmpConn struct {
+	*icmp.PacketConn
+	v4 bool
+}
+
+func (c *icmpConn) write(b []byte, dst net.Addr, ttl int) error {
+	if ttl > 0 {
+		var err error
+		if c.v4 {
+			err = c.IPv4PacketConn().SetTTL(ttl)
+		} else {
+			err = c.IPv6PacketConn().SetHopLimit(ttl)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := c.WriteTo(b, dst)
+	return err
+}
+
+func (c *icmpConn) read(b []byte) (int, net.Addr, error) {
+	return c.ReadFrom(b)
+}
+
+// rawConn 自己构造ip头的raw socket，用于设置DF位
+type rawConn struct {
+	*ipv4.RawConn
+	src net.IP
+}
+
+func (c *rawConn) write(b []byte, dst net.Addr, ttl int) error {
+	if ttl == 0 {
+		ttl = 64
+	}
+	header := &ipv4.Header{
+		Version:  ipv4.Version,
+		Len:      ipv4.HeaderLen,
+		TotalLen: ipv4.HeaderLen + len(b),
+		Protocol: 1,
+		TTL:      ttl,
+		Flags:    ipv4.DontFragment,
+		Dst:      dst.(*net.IPAddr).IP,
+		Src:      c.src,
+	}
+	return c.WriteTo(header, b, nil)
+}
+
+func (c *rawConn) read(b []byte) (int, net.Addr, error) {
+	h, p, _, err := c.ReadFrom(b)
+	if err != nil {
+		return 0, nil, err
+	}
+	n := copy(b, p)
+	return n, &net.IPAddr{IP: h.Src}, nil
+}
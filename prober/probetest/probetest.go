@@ -0,0 +1,84 @@
+// Package probetest 各prober测试共用的辅助函数：执行探测、读取registry中的metrics和校验失败原因
+package probetest
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/utils"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ProbeFn prober的探测函数，与 prober.ProbeFn 相同
+type ProbeFn func(ctx context.Context, target string, module conf.Module, registry *prometheus.Registry) bool
+
+// Probe 在带有超时和失败原因的ctx中执行一次探测，返回探测结果、失败原因和本次探测的registry
+func Probe(t testing.TB, probeFn ProbeFn, target string, module conf.Module) (bool, utils.FailureReason, *prometheus.Registry) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ctx, failureReason := utils.NewFailureContext(ctx)
+	registry := prometheus.NewRegistry()
+	result := probeFn(ctx, target, module, registry)
+	return result, failureReason(), registry
+}
+
+// CheckResult 校验探测结果和失败原因，探测成功时失败原因应为空
+func CheckResult(t testing.TB, result bool, reason utils.FailureReason, expectedResult bool, expectedReason utils.FailureReason) {
+	t.Helper()
+	if result != expectedResult || reason != expectedReason {
+		t.Fatalf("Expected probe result %t with failure reason %q, got %t %q", expectedResult, expectedReason, result, reason)
+	}
+}
+
+// Gather 返回registry中的metrics，有label时以 name{value1,value2} 为key
+func Gather(t testing.TB, registry *prometheus.Registry) map[string]float64 {
+	t.Helper()
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := map[string]float64{}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			name := mf.GetName()
+			if len(m.GetLabel()) > 0 {
+				values := make([]string, 0, len(m.GetLabel()))
+				for _, label := range m.GetLabel() {
+					values = append(values, label.GetValue())
+				}
+				name += "{" + strings.Join(values, ",") + "}"
+			}
+			results[name] = m.GetGauge().GetValue()
+		}
+	}
+	return results
+}
+
+// CheckResults 校验registry中的metrics取值，key的格式与 Gather 相同
+func CheckResults(t testing.TB, registry *prometheus.Registry, expected map[string]float64) {
+	t.Helper()
+	results := Gather(t, registry)
+	for name, want := range expected {
+		got, ok := results[name]
+		if !ok {
+			t.Errorf("Expected metric %s not found", name)
+			continue
+		}
+		if got != want {
+			t.Errorf("Expected %s to be %v, got %v", name, want, got)
+		}
+	}
+}
+
+// Certificate 返回httptest生成的自签名证书，证书包含 example.com 和 127.0.0.1
+func Certificate() tls.Certificate {
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	defer ts.Close()
+	return ts.TLS.Certificates[0]
+}
@@ -0,0 +1,216 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/utils"
+	"go.uber.org/zap"
+	"io"
+	"net"
+)
+
+// logger 返回本次探测使用的logger
+func logger(ctx context.Context) *zap.Logger {
+	return utils.LoggerFromContext(ctx, "TCP")
+}
+
+// dialTCP 解析target并建立tcp连接，tcp.TLS 为true时同时完成TLS握手
+func dialTCP(ctx context.Context, target string, tcpConfig conf.TCPProbe, registry *prometheus.Registry) (net.Conn, error) {
+	l := logger(ctx)
+
+	targetAddress, port, err := net.SplitHostPort(target)
+	if err != nil {
+		l.Error("Error splitting target address and port", zap.Error(err))
+		utils.RecordFailure(ctx, utils.FailureInvalidTarget)
+		return nil, err
+	}
+
+	ip, _, err := conf.ChooseProtocol(ctx, tcpConfig.IPProtocol, tcpConfig.IPProtocolFallback, targetAddress, registry)
+	if err != nil {
+		l.Error("Error resolving address", zap.Error(err))
+		return nil, err
+	}
+
+	dialProtocol := "tcp6"
+	if ip.IP.To4() != nil {
+		dialProtocol = "tcp4"
+	}
+
+	dialer := &net.Dialer{}
+	if tcpConfig.SourceIPAddress != "" {
+		dialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(tcpConfig.SourceIPAddress)}
+	}
+
+	dialTarget := net.JoinHostPort(ip.String(), port)
+	l.Info("Dialing TCP", zap.String("address", dialTarget), zap.Bool("tls", tcpConfig.TLS))
+	conn, err := dialer.DialContext(ctx, dialProtocol, dialTarget)
+	if err != nil {
+		reason := utils.ClassifyError(err)
+		l.Error("Error dialing TCP", zap.String("reason", string(reason)), zap.Error(err))
+		utils.RecordFailure(ctx, reason)
+		return nil, err
+	}
+
+	if !tcpConfig.TLS {
+		return conn, nil
+	}
+	tlsConfig, err := newTLSConfig(tcpConfig, targetAddress)
+	if err != nil {
+		l.Error("Error creating TLS configuration", zap.Error(err))
+		utils.RecordFailure(ctx, utils.FailureConfig)
+		conn.Close()
+		return nil, err
+	}
+	return handshake(ctx, conn, tlsConfig)
+}
+
+// newTLSConfig 未配置server_name时使用target的host
+func newTLSConfig(tcpConfig conf.TCPProbe, host string) (*tls.Config, error) {
+	tlsConfig, err := pconfig.NewTLSConfig(&tcpConfig.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = host
+	}
+	return tlsConfig, nil
+}
+
+// handshake 在已有连接上完成TLS握手，用于tls和starttls
+func handshake(ctx context.Context, conn net.Conn, tlsConfig *tls.Config) (*tls.Conn, error) {
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		reason := utils.ClassifyError(err)
+		if reason == utils.FailureUnknown {
+			reason = utils.FailureTLSHandshake
+		}
+		logger(ctx).Error("TLS handshake failed", zap.String("reason", string(reason)), zap.Error(err))
+		utils.RecordFailure(ctx, reason)
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// readLine 读取一行并去掉结尾的 \r\n，最后一行可以没有换行符，没有数据时返回 io.EOF
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadBytes('\n')
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	return bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r")), err
+}
+
+// ProbeTCP 建立tcp连接，并按照 query_response 依次进行对话
+func ProbeTCP(ctx context.Context, target string, module conf.Module, registry *prometheus.Registry) bool {
+	var (
+		probeFailedDueToRegex = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_failed_due_to_regex",
+			Help: "Indicates if probe failed due to regex",
+		})
+	)
+	registry.MustRegister(probeFailedDueToRegex)
+
+	l := logger(ctx)
+	tcpConfig := *module.TCP
+
+	conn, err := dialTCP(ctx, target, tcpConfig, registry)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	l.Info("Successfully dialed")
+
+	// 对话的所有读写都不能超过探测的超时时间
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			l.Error("Error setting deadline", zap.Error(err))
+			return false
+		}
+	}
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		utils.RegisterTLSStateMetrics(registry, &state)
+	}
+
+	reader := bufio.NewReader(conn)
+	for i, qr := range tcpConfig.QueryResponse {
+		l.Info("Processing query response entry", zap.Int("entry_number", i))
+		send := qr.Send
+		if qr.Expect.Regexp != nil {
+			var (
+				line  []byte
+				match []int
+				err   error
+			)
+			// 逐行读取直到匹配，e.g. 跳过SMTP多行的欢迎信息
+			for {
+				if line, err = readLine(reader); err != nil {
+					break
+				}
+				l.Debug("Read line", zap.ByteString("line", line))
+				match = qr.Expect.FindSubmatchIndex(line)
+				if match != nil {
+					l.Info("Regexp matched", zap.String("regexp", qr.Expect.String()), zap.ByteString("line", line))
+					break
+				}
+			}
+			if err != nil && err != io.EOF {
+				reason := utils.ClassifyError(err)
+				l.Error("Error reading from connection", zap.String("reason", string(reason)), zap.Error(err))
+				utils.RecordFailure(ctx, reason)
+				return false
+			}
+			if match == nil {
+				l.Error("Regexp did not match", zap.String("regexp", qr.Expect.String()))
+				probeFailedDueToRegex.Set(1)
+				utils.RecordFailure(ctx, utils.FailureRegexResponse)
+				return false
+			}
+			probeFailedDueToRegex.Set(0)
+			send = string(qr.Expect.Expand(nil, []byte(send), line, match))
+		}
+		if send != "" {
+			l.Debug("Sending line", zap.String("line", send))
+			if _, err := fmt.Fprintf(conn, "%s\r\n", send); err != nil {
+				reason := utils.ClassifyError(err)
+				l.Error("Failed to send", zap.String("reason", string(reason)), zap.Error(err))
+				utils.RecordFailure(ctx, reason)
+				return false
+			}
+		}
+		if qr.StartTLS {
+			// 升级前服务端已经发送的数据不属于TLS握手，继续升级会丢失这些数据，e.g. STARTTLS命令注入
+			if n := reader.Buffered(); n > 0 {
+				l.Error("Unexpected data received before upgrading to TLS", zap.Int("bytes", n))
+				utils.RecordFailure(ctx, utils.FailureTLSHandshake)
+				return false
+			}
+			l.Info("Upgrading connection to TLS")
+			targetAddress, _, _ := net.SplitHostPort(target)
+			tlsConfig, err := newTLSConfig(tcpConfig, targetAddress)
+			if err != nil {
+				l.Error("Error creating TLS configuration", zap.Error(err))
+				utils.RecordFailure(ctx, utils.FailureConfig)
+				return false
+			}
+			tlsConn, err := handshake(ctx, conn, tlsConfig)
+			if err != nil {
+				return false
+			}
+			conn = tlsConn
+			// 升级后的对话从TLS连接上读取
+			reader = bufio.NewReader(conn)
+			state := tlsConn.ConnectionState()
+			utils.RegisterTLSStateMetrics(registry, &state)
+		}
+	}
+	return true
+}
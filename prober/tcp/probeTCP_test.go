@@ -0,0 +1,201 @@
+package tcp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/prober/probetest"
+	"github.com/yuanyp8/http_exporter/utils"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestModule(tcpProbe *conf.TCPProbe) conf.Module {
+	return conf.Module{
+		Prober:  "tcp",
+		Timeout: time.Second,
+		TCP:     tcpProbe,
+	}
+}
+
+// serve 在本地监听，每个连接交给handler处理
+func serve(t *testing.T, ln net.Listener, handler func(conn net.Conn)) {
+	t.Helper()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handler(conn)
+			}()
+		}
+	}()
+}
+
+func TestTCPConnection(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	serve(t, ln, func(conn net.Conn) {})
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	tests := []struct {
+		name           string
+		target         string
+		expectedResult bool
+		expectedReason utils.FailureReason
+	}{
+		{"open port", ln.Addr().String(), true, ""},
+		{"closed port", closed.Addr().String(), false, utils.FailureConnectRefused},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, reason, _ := probetest.Probe(t, ProbeTCP, test.target, newTestModule(conf.NewDefaultTCPProbe()))
+			probetest.CheckResult(t, result, reason, test.expectedResult, test.expectedReason)
+		})
+	}
+}
+
+func TestTCPQueryResponse(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	serve(t, ln, func(conn net.Conn) {
+		conn.Write([]byte("SSH-2.0-OpenSSH_8.9\r\ntoken 42\n"))
+		scanner := bufio.NewScanner(conn)
+		if scanner.Scan() && scanner.Text() == "PING 42" {
+			conn.Write([]byte("+PONG\n"))
+		} else {
+			conn.Write([]byte("-ERR\n"))
+		}
+	})
+
+	tests := []struct {
+		name           string
+		queryResponse  []conf.QueryResponse
+		expectedResult bool
+		expectedReason utils.FailureReason
+	}{
+		{"expect banner", []conf.QueryResponse{{Expect: *conf.MustNewRegexp("^SSH-2.0-")}}, true, ""},
+		{"send with submatch", []conf.QueryResponse{
+			{Expect: *conf.MustNewRegexp(`^token (\d+)`), Send: "PING ${1}"},
+			{Expect: *conf.MustNewRegexp(`^\+PONG`)},
+		}, true, ""},
+		{"unexpected response", []conf.QueryResponse{
+			{Expect: *conf.MustNewRegexp(`^token`), Send: "PING"},
+			{Expect: *conf.MustNewRegexp(`^\+PONG`)},
+		}, false, utils.FailureRegexResponse},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tcpProbe := conf.NewDefaultTCPProbe()
+			tcpProbe.QueryResponse = test.queryResponse
+			result, reason, _ := probetest.Probe(t, ProbeTCP, ln.Addr().String(), newTestModule(tcpProbe))
+			probetest.CheckResult(t, result, reason, test.expectedResult, test.expectedReason)
+		})
+	}
+}
+
+func TestTCPTLS(t *testing.T) {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{probetest.Certificate()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	serve(t, ln, func(conn net.Conn) {
+		conn.Write([]byte("hello\n"))
+	})
+
+	tests := []struct {
+		name               string
+		insecureSkipVerify bool
+		expectedResult     bool
+		expectedReason     utils.FailureReason
+	}{
+		// 自签名证书校验失败
+		{"self-signed certificate", false, false, utils.FailureCertInvalid},
+		{"insecure_skip_verify", true, true, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tcpProbe := conf.NewDefaultTCPProbe()
+			tcpProbe.TLS = true
+			tcpProbe.TLSConfig.InsecureSkipVerify = test.insecureSkipVerify
+			tcpProbe.QueryResponse = []conf.QueryResponse{{Expect: *conf.MustNewRegexp("^hello")}}
+
+			result, reason, registry := probetest.Probe(t, ProbeTCP, ln.Addr().String(), newTestModule(tcpProbe))
+			probetest.CheckResult(t, result, reason, test.expectedResult, test.expectedReason)
+			if test.expectedResult {
+				probetest.CheckResults(t, registry, map[string]float64{"probe_tls_version_info{TLS 1.3}": 1})
+			}
+		})
+	}
+}
+
+func TestTCPStartTLS(t *testing.T) {
+	cert := probetest.Certificate()
+
+	tests := []struct {
+		name           string
+		goAhead        string
+		expectedResult bool
+		expectedReason utils.FailureReason
+	}{
+		{"starttls", "220 Go ahead\r\n", true, ""},
+		// 升级前多发送的数据会在TLS握手时丢失，不能继续升级
+		{"data before upgrade", "220 Go ahead\r\n250 injected\r\n", false, utils.FailureTLSHandshake},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			serve(t, ln, func(conn net.Conn) {
+				conn.Write([]byte("220 ESMTP ready\r\n"))
+				// 命令必须以 \r\n 结尾
+				line, err := bufio.NewReader(conn).ReadString('\n')
+				if err != nil || line != "STARTTLS\r\n" {
+					return
+				}
+				conn.Write([]byte(test.goAhead))
+				tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+				if err := tlsConn.Handshake(); err != nil {
+					return
+				}
+				tlsConn.Write([]byte("250 OK\r\n"))
+			})
+
+			tcpProbe := conf.NewDefaultTCPProbe()
+			tcpProbe.TLSConfig.InsecureSkipVerify = true
+			tcpProbe.QueryResponse = []conf.QueryResponse{
+				{Expect: *conf.MustNewRegexp("^220 ESMTP"), Send: "STARTTLS"},
+				{Expect: *conf.MustNewRegexp("^220"), StartTLS: true},
+				{Expect: *conf.MustNewRegexp("^250 OK$")},
+			}
+			result, reason, registry := probetest.Probe(t, ProbeTCP, ln.Addr().String(), newTestModule(tcpProbe))
+			probetest.CheckResult(t, result, reason, test.expectedResult, test.expectedReason)
+			if test.expectedResult {
+				probetest.CheckResults(t, registry, map[string]float64{"probe_tls_version_info{TLS 1.3}": 1})
+			}
+		})
+	}
+}
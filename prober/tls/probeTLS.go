@@ -0,0 +1,171 @@
+package tls
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/prober/tlscheck"
+	"github.com/yuanyp8/http_exporter/utils"
+	"go.uber.org/zap"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// logger 返回本次探测使用的logger
+func logger(ctx context.Context) *zap.Logger {
+	return utils.LoggerFromContext(ctx, "TLS")
+}
+
+// ProbeTLS 与target完成TLS握手，导出整条证书链的信息以及证书链是否可信
+// 握手时总是跳过校验以便拿到不可信的证书链，之后再单独校验，未配置 insecure_skip_verify 时校验失败探测失败
+func ProbeTLS(ctx context.Context, target string, module conf.Module, registry *prometheus.Registry) bool {
+	var (
+		durationGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_tls_duration_seconds",
+			Help: "Duration of tls handshake by phase",
+		}, []string{"phase"})
+
+		chainVerifiedGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_ssl_chain_verified",
+			Help: "Indicates if the presented certificate chain verifies against the system or configured roots and server name",
+		})
+
+		certInfoGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_ssl_cert_info",
+			Help: "Contains information of every certificate in the presented chain, position 0 is the leaf",
+		}, []string{"position", "subject", "issuer", "sans", "serial_number", "key_type", "signature_algorithm"})
+
+		certKeySizeGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_ssl_cert_key_size_bits",
+			Help: "Returns the public key size of every certificate in the presented chain",
+		}, []string{"position"})
+
+		certNotBeforeGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_ssl_cert_not_before_timestamp_seconds",
+			Help: "Returns the not before time of every certificate in the presented chain in unixtime",
+		}, []string{"position"})
+
+		certNotAfterGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_ssl_cert_not_after_timestamp_seconds",
+			Help: "Returns the not after time of every certificate in the presented chain in unixtime",
+		}, []string{"position"})
+	)
+
+	for _, lv := range []string{"resolve", "connect", "tls"} {
+		durationGaugeVec.WithLabelValues(lv)
+	}
+	registry.MustRegister(durationGaugeVec, chainVerifiedGauge)
+
+	l := logger(ctx)
+	tlsProbe := *module.TLS
+
+	port := "443"
+	targetAddress, targetPort, err := net.SplitHostPort(target)
+	if err != nil {
+		// target 中没有端口，使用默认端口
+		targetAddress = strings.Trim(target, "[]")
+	} else {
+		port = targetPort
+	}
+
+	ip, lookupTime, err := conf.ChooseProtocol(ctx, tlsProbe.IPProtocol, tlsProbe.IPProtocolFallback, targetAddress, registry)
+	if err != nil {
+		l.Error("Error resolving address", zap.Error(err))
+		return false
+	}
+	durationGaugeVec.WithLabelValues("resolve").Add(lookupTime)
+
+	tlsConfig, err := pconfig.NewTLSConfig(&tlsProbe.TLSConfig)
+	if err != nil {
+		l.Error("Error creating TLS configuration", zap.Error(err))
+		utils.RecordFailure(ctx, utils.FailureConfig)
+		return false
+	}
+	// 与http探测一致，未配置server_name时使用target的host
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = targetAddress
+	}
+	verify := !tlsConfig.InsecureSkipVerify
+	tlsConfig.InsecureSkipVerify = true
+
+	dialProtocol := "tcp6"
+	if ip.IP.To4() != nil {
+		dialProtocol = "tcp4"
+	}
+	dialTarget := net.JoinHostPort(ip.String(), port)
+
+	l.Info("Dialing TCP", zap.String("address", dialTarget), zap.String("server_name", tlsConfig.ServerName))
+	connectStart := time.Now()
+	conn, err := (&net.Dialer{}).DialContext(ctx, dialProtocol, dialTarget)
+	durationGaugeVec.WithLabelValues("connect").Add(time.Since(connectStart).Seconds())
+	if err != nil {
+		reason := utils.ClassifyError(err)
+		l.Error("Error dialing TCP", zap.String("reason", string(reason)), zap.Error(err))
+		utils.RecordFailure(ctx, reason)
+		return false
+	}
+	defer conn.Close()
+
+	tlsStart := time.Now()
+	tlsConn := tls.Client(conn, tlsConfig)
+	err = tlsConn.HandshakeContext(ctx)
+	durationGaugeVec.WithLabelValues("tls").Add(time.Since(tlsStart).Seconds())
+	if err != nil {
+		reason := utils.ClassifyError(err)
+		if reason == utils.FailureUnknown {
+			reason = utils.FailureTLSHandshake
+		}
+		l.Error("TLS handshake failed", zap.String("reason", string(reason)), zap.Error(err))
+		utils.RecordFailure(ctx, reason)
+		return false
+	}
+	state := tlsConn.ConnectionState()
+
+	chains, verifyErr := utils.VerifyCertificates(state.PeerCertificates, tlsConfig.RootCAs, tlsConfig.ServerName)
+	state.VerifiedChains = chains
+
+	registry.MustRegister(certInfoGaugeVec, certKeySizeGaugeVec, certNotBeforeGaugeVec, certNotAfterGaugeVec)
+	for i, cert := range state.PeerCertificates {
+		position := strconv.Itoa(i)
+		keyType, keySize := utils.GetKeyTypeAndSize(cert)
+		certInfoGaugeVec.WithLabelValues(
+			position,
+			cert.Subject.String(),
+			cert.Issuer.String(),
+			utils.GetSubjectAlternativeNames(cert),
+			cert.SerialNumber.Text(16),
+			keyType,
+			cert.SignatureAlgorithm.String(),
+		).Set(1)
+		certKeySizeGaugeVec.WithLabelValues(position).Set(float64(keySize))
+		certNotBeforeGaugeVec.WithLabelValues(position).Set(float64(cert.NotBefore.Unix()))
+		certNotAfterGaugeVec.WithLabelValues(position).Set(float64(cert.NotAfter.Unix()))
+	}
+	utils.RegisterTLSStateMetrics(registry, &state)
+
+	if verifyErr != nil {
+		l.Warn("Certificate chain did not verify", zap.Bool("insecure_skip_verify", !verify), zap.Error(verifyErr))
+	} else {
+		chainVerifiedGauge.Set(1)
+		l.Info("Certificate chain verified", zap.Int("certificates", len(state.PeerCertificates)))
+	}
+
+	// 查询OCSP responder和CRL时使用与握手相同的ca等配置
+	revocationClientConfig := pconfig.DefaultHTTPClientConfig
+	revocationClientConfig.TLSConfig = tlsProbe.TLSConfig
+	if !tlscheck.CheckRevocation(ctx, tlsProbe.Revocation, revocationClientConfig, &state, registry) {
+		return false
+	}
+	if !tlscheck.CheckPins(ctx, tlsProbe.CertificatePins, state.PeerCertificates, registry) {
+		return false
+	}
+	if verifyErr != nil && verify {
+		utils.RecordFailure(ctx, utils.FailureCertInvalid)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,172 @@
+package tls
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/prober/probetest"
+	"github.com/yuanyp8/http_exporter/utils"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestModule(tlsProbe *conf.TLSProbe) conf.Module {
+	return conf.Module{
+		Prober:  "tls",
+		Timeout: time.Second,
+		TLS:     tlsProbe,
+	}
+}
+
+// startTLSServer 启动httptest的TLS服务器，返回地址以及证书对应的ca文件
+func startTLSServer(t *testing.T) (string, string) {
+	t.Helper()
+	ts := httptest.NewUnstartedServer(nil)
+	// 探测只完成握手不发送请求，忽略服务端的握手日志
+	ts.Config.ErrorLog = log.New(io.Discard, "", 0)
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return ts.Listener.Addr().String(), caFile
+}
+
+func TestTLSChainVerification(t *testing.T) {
+	target, caFile := startTLSServer(t)
+
+	tests := []struct {
+		name             string
+		configure        func(tlsProbe *conf.TLSProbe)
+		expectedResult   bool
+		expectedVerified float64
+	}{
+		{"system roots", func(*conf.TLSProbe) {}, false, 0},
+		{"insecure_skip_verify", func(p *conf.TLSProbe) { p.TLSConfig.InsecureSkipVerify = true }, true, 0},
+		{"ca_file", func(p *conf.TLSProbe) { p.TLSConfig.CAFile = caFile }, true, 1},
+		{"server_name", func(p *conf.TLSProbe) {
+			p.TLSConfig.CAFile = caFile
+			p.TLSConfig.ServerName = "example.com"
+		}, true, 1},
+		{"wrong server_name", func(p *conf.TLSProbe) {
+			p.TLSConfig.CAFile = caFile
+			p.TLSConfig.ServerName = "example.org"
+		}, false, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tlsProbe := conf.NewDefaultTLSProbe()
+			test.configure(tlsProbe)
+
+			var expectedReason utils.FailureReason
+			if !test.expectedResult {
+				expectedReason = utils.FailureCertInvalid
+			}
+			result, reason, registry := probetest.Probe(t, ProbeTLS, target, newTestModule(tlsProbe))
+			probetest.CheckResult(t, result, reason, test.expectedResult, expectedReason)
+			probetest.CheckResults(t, registry, map[string]float64{"probe_ssl_chain_verified": test.expectedVerified})
+		})
+	}
+}
+
+func TestTLSCertificateMetrics(t *testing.T) {
+	target, caFile := startTLSServer(t)
+	tlsProbe := conf.NewDefaultTLSProbe()
+	tlsProbe.TLSConfig.CAFile = caFile
+
+	result, reason, registry := probetest.Probe(t, ProbeTLS, target, newTestModule(tlsProbe))
+	probetest.CheckResult(t, result, reason, true, "")
+
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, mf := range mfs {
+		if mf.GetName() != "probe_ssl_cert_info" {
+			continue
+		}
+		labels := map[string]string{}
+		for _, label := range mf.GetMetric()[0].GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		if labels["position"] != "0" || !strings.Contains(labels["subject"], "Acme Co") || labels["key_type"] != "RSA" ||
+			!strings.Contains(labels["sans"], "example.com") || !strings.Contains(labels["sans"], "127.0.0.1") ||
+			labels["serial_number"] == "" || labels["signature_algorithm"] != "SHA256-RSA" {
+			t.Fatalf("Unexpected probe_ssl_cert_info labels: %v", labels)
+		}
+		found = true
+	}
+	if !found {
+		t.Fatal("probe_ssl_cert_info not found")
+	}
+	results := probetest.Gather(t, registry)
+	if size := results["probe_ssl_cert_key_size_bits{0}"]; size < 1024 {
+		t.Errorf("Unexpected key size %v", size)
+	}
+	notBefore := results["probe_ssl_cert_not_before_timestamp_seconds{0}"]
+	notAfter := results["probe_ssl_cert_not_after_timestamp_seconds{0}"]
+	if notAfter <= notBefore {
+		t.Errorf("Unexpected validity period %v - %v", notBefore, notAfter)
+	}
+	probetest.CheckResults(t, registry, map[string]float64{"probe_ssl_last_chain_expiry_timestamp_seconds": notAfter})
+}
+
+func TestTLSCertificatePins(t *testing.T) {
+	target, caFile := startTLSServer(t)
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(caPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	fingerprint := sha256.Sum256(cert.Raw)
+	other := sha256.Sum256([]byte("other"))
+
+	tests := []struct {
+		name           string
+		pins           conf.PinConfig
+		expectedResult bool
+	}{
+		{"spki base64", conf.PinConfig{SPKISHA256: []string{"sha256/" + base64.StdEncoding.EncodeToString(spki[:])}}, true},
+		{"spki hex", conf.PinConfig{SPKISHA256: []string{hex.EncodeToString(other[:]), hex.EncodeToString(spki[:])}}, true},
+		{"fingerprint", conf.PinConfig{FingerprintSHA256: []string{strings.ToUpper(hex.EncodeToString(fingerprint[:]))}}, true},
+		{"mismatch", conf.PinConfig{
+			SPKISHA256:        []string{hex.EncodeToString(other[:])},
+			FingerprintSHA256: []string{hex.EncodeToString(other[:])},
+		}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tlsProbe := conf.NewDefaultTLSProbe()
+			tlsProbe.TLSConfig.CAFile = caFile
+			tlsProbe.CertificatePins = test.pins
+
+			expectedReason, expectedMatched := utils.FailurePinMismatch, 0.0
+			if test.expectedResult {
+				expectedReason, expectedMatched = "", 1
+			}
+			result, reason, registry := probetest.Probe(t, ProbeTLS, target, newTestModule(tlsProbe))
+			probetest.CheckResult(t, result, reason, test.expectedResult, expectedReason)
+			probetest.CheckResults(t, registry, map[string]float64{"probe_ssl_pin_matched": expectedMatched})
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package tlscheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/utils"
+	"go.uber.org/zap"
+	"strconv"
+)
+
+// 匹配到的pin类型，作为 type label
+const (
+	pinTypeSPKI        = "spki_sha256"
+	pinTypeFingerprint = "fingerprint_sha256"
+)
+
+// CheckPins 检查证书链中是否有证书匹配配置的pin并导出metrics，未配置pin时什么也不做
+// certs 为空(e.g. 没有使用TLS)时视为不匹配，供http和tls探测复用
+func CheckPins(ctx context.Context, p conf.PinConfig, certs []*x509.Certificate, registry prometheus.Registerer) bool {
+	if !p.Enabled() {
+		return true
+	}
+
+	var (
+		pinMatchedGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_ssl_pin_matched",
+			Help: "Indicates if any certificate in the presented chain matches a configured pin",
+		})
+
+		pinMatchInfoGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_ssl_pin_match_info",
+			Help: "Contains the position of the certificates matching a configured pin, position 0 is the leaf",
+		}, []string{"position", "type"})
+	)
+	registry.MustRegister(pinMatchedGauge, pinMatchInfoGaugeVec)
+
+	l := utils.LoggerFromContext(ctx, "Pin")
+	spkiPins, fingerprintPins := p.Digests()
+
+	matched := false
+	for i, cert := range certs {
+		position := strconv.Itoa(i)
+		spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		if containsPin(spkiPins, spki[:]) {
+			pinMatchInfoGaugeVec.WithLabelValues(position, pinTypeSPKI).Set(1)
+			matched = true
+		}
+		fingerprint := sha256.Sum256(cert.Raw)
+		if containsPin(fingerprintPins, fingerprint[:]) {
+			pinMatchInfoGaugeVec.WithLabelValues(position, pinTypeFingerprint).Set(1)
+			matched = true
+		}
+	}
+
+	if !matched {
+		l.Error("No certificate in the presented chain matches the configured pins", zap.Int("certificates", len(certs)))
+		utils.RecordFailure(ctx, utils.FailurePinMismatch)
+		return false
+	}
+	pinMatchedGauge.Set(1)
+	return true
+}
+
+func containsPin(pins [][]byte, digest []byte) bool {
+	for _, pin := range pins {
+		if bytes.Equal(pin, digest) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,273 @@
+// Package tlscheck http和tls探测共用的证书检查：吊销状态和证书锁定
+package tlscheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/utils"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ocsp"
+	"io"
+	"net/http"
+	"time"
+)
+
+// 吊销状态的来源，作为 source label
+const (
+	revocationSourceStapled = "stapled"
+	revocationSourceOCSP    = "ocsp"
+	revocationSourceCRL     = "crl"
+)
+
+// revocationStatus 某一来源给出的吊销状态
+type revocationStatus struct {
+	status     string // good、revoked 或 unknown
+	thisUpdate time.Time
+	nextUpdate time.Time
+}
+
+// maxRevocationResponseSize OCSP响应和CRL的最大长度
+const maxRevocationResponseSize = 16 << 20
+
+var ocspStatuses = map[int]string{
+	ocsp.Good:    "good",
+	ocsp.Revoked: "revoked",
+	ocsp.Unknown: "unknown",
+}
+
+// CheckRevocation 检查叶子证书的吊销状态并导出metrics，任意来源报告证书已被吊销时返回false
+// 查询OCSP responder或CRL使用模块的 http_client_config，e.g. 代理和ca，失败时只记录日志，不影响探测结果
+// 没有开启任何检查时什么也不做，供http和tls探测复用
+func CheckRevocation(ctx context.Context, r conf.RevocationConfig, httpClientConfig pconfig.HTTPClientConfig, state *tls.ConnectionState, registry prometheus.Registerer) bool {
+	if !r.Enabled() {
+		return true
+	}
+
+	var (
+		ocspStapledGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_ssl_ocsp_stapled",
+			Help: "Indicates if the server stapled an OCSP response during the handshake",
+		})
+
+		statusGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_ssl_revocation_status_info",
+			Help: "Contains the revocation status of the leaf certificate by source",
+		}, []string{"source", "status"})
+
+		thisUpdateGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_ssl_revocation_this_update_timestamp_seconds",
+			Help: "Returns the this update time of the revocation information by source",
+		}, []string{"source"})
+
+		nextUpdateGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_ssl_revocation_next_update_timestamp_seconds",
+			Help: "Returns the next update time of the revocation information by source, 0 if not set",
+		}, []string{"source"})
+	)
+	registry.MustRegister(ocspStapledGauge, statusGaugeVec, thisUpdateGaugeVec, nextUpdateGaugeVec)
+
+	l := utils.LoggerFromContext(ctx, "Revocation")
+	if len(state.PeerCertificates) == 0 {
+		return true
+	}
+	leaf := state.PeerCertificates[0]
+	issuer := findIssuer(state)
+
+	revoked := false
+	report := func(source string, status *revocationStatus) {
+		l.Info("Got revocation status", zap.String("source", source), zap.String("status", status.status), zap.Time("this_update", status.thisUpdate), zap.Time("next_update", status.nextUpdate))
+		statusGaugeVec.WithLabelValues(source, status.status).Set(1)
+		thisUpdateGaugeVec.WithLabelValues(source).Set(float64(status.thisUpdate.Unix()))
+		if !status.nextUpdate.IsZero() {
+			nextUpdateGaugeVec.WithLabelValues(source).Set(float64(status.nextUpdate.Unix()))
+		} else {
+			nextUpdateGaugeVec.WithLabelValues(source).Set(0)
+		}
+		if status.status == "revoked" {
+			revoked = true
+		}
+	}
+
+	if len(state.OCSPResponse) > 0 {
+		ocspStapledGauge.Set(1)
+	}
+	if r.CheckStapledOCSP && len(state.OCSPResponse) > 0 {
+		if status, err := parseOCSPResponse(state.OCSPResponse, leaf, issuer); err != nil {
+			l.Warn("Error parsing stapled OCSP response", zap.Error(err))
+		} else {
+			report(revocationSourceStapled, status)
+		}
+	}
+
+	var client *http.Client
+	if r.QueryOCSPResponder || r.QueryCRL {
+		var err error
+		if client, err = newRevocationClient(httpClientConfig); err != nil {
+			l.Error("Error generating HTTP client for revocation queries", zap.Error(err))
+		}
+	}
+
+	if r.QueryOCSPResponder && client != nil {
+		if status, err := queryOCSPResponder(ctx, client, leaf, issuer); err != nil {
+			l.Warn("Error querying OCSP responder", zap.Strings("ocsp_server", leaf.OCSPServer), zap.Error(err))
+		} else {
+			report(revocationSourceOCSP, status)
+		}
+	}
+
+	if r.QueryCRL && client != nil {
+		if status, err := queryCRL(ctx, client, leaf, issuer); err != nil {
+			l.Warn("Error checking CRL", zap.Strings("crl_distribution_points", leaf.CRLDistributionPoints), zap.Error(err))
+		} else {
+			report(revocationSourceCRL, status)
+		}
+	}
+
+	if revoked {
+		l.Error("Leaf certificate has been revoked", zap.String("serial_number", leaf.SerialNumber.Text(16)))
+		utils.RecordFailure(ctx, utils.FailureCertRevoked)
+		return false
+	}
+	return true
+}
+
+// findIssuer 返回叶子证书的签发者，优先使用通过校验的证书链
+func findIssuer(state *tls.ConnectionState) *x509.Certificate {
+	for _, chain := range state.VerifiedChains {
+		if len(chain) > 1 {
+			return chain[1]
+		}
+	}
+	if len(state.PeerCertificates) > 1 {
+		return state.PeerCertificates[1]
+	}
+	return nil
+}
+
+// parseOCSPResponse 解析OCSP响应，校验签名以及响应是否对应叶子证书
+func parseOCSPResponse(raw []byte, leaf, issuer *x509.Certificate) (*revocationStatus, error) {
+	if issuer == nil {
+		return nil, fmt.Errorf("issuer certificate not presented")
+	}
+	resp, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return nil, err
+	}
+	return &revocationStatus{
+		status:     ocspStatuses[resp.Status],
+		thisUpdate: resp.ThisUpdate,
+		nextUpdate: resp.NextUpdate,
+	}, nil
+}
+
+// queryOCSPResponder 依次向叶子证书中的OCSP地址发起查询，返回第一个成功的结果
+func queryOCSPResponder(ctx context.Context, client *http.Client, leaf, issuer *x509.Certificate) (*revocationStatus, error) {
+	if len(leaf.OCSPServer) == 0 {
+		return nil, fmt.Errorf("certificate has no OCSP server")
+	}
+	if issuer == nil {
+		return nil, fmt.Errorf("issuer certificate not presented")
+	}
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, server := range leaf.OCSPServer {
+		raw, err := fetch(ctx, client, http.MethodPost, server, req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		status, err := parseOCSPResponse(raw, leaf, issuer)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return status, nil
+	}
+	return nil, lastErr
+}
+
+// queryCRL 依次下载叶子证书中的CRL分发点，返回第一个成功的结果
+func queryCRL(ctx context.Context, client *http.Client, leaf, issuer *x509.Certificate) (*revocationStatus, error) {
+	if len(leaf.CRLDistributionPoints) == 0 {
+		return nil, fmt.Errorf("certificate has no CRL distribution point")
+	}
+	// 没有签发者证书时无法校验CRL的签名
+	if issuer == nil {
+		return nil, fmt.Errorf("issuer certificate not presented")
+	}
+
+	var lastErr error
+	for _, dp := range leaf.CRLDistributionPoints {
+		raw, err := fetch(ctx, client, http.MethodGet, dp, nil)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		// CRL一般是DER格式，也兼容PEM
+		if block, _ := pem.Decode(raw); block != nil {
+			raw = block.Bytes
+		}
+		crl, err := x509.ParseRevocationList(raw)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if err := crl.CheckSignatureFrom(issuer); err != nil {
+			lastErr = err
+			continue
+		}
+		status := &revocationStatus{status: "good", thisUpdate: crl.ThisUpdate, nextUpdate: crl.NextUpdate}
+		for _, revokedCert := range crl.RevokedCertificates {
+			if revokedCert.SerialNumber.Cmp(leaf.SerialNumber) == 0 {
+				status.status = "revoked"
+				break
+			}
+		}
+		return status, nil
+	}
+	return nil, lastErr
+}
+
+// newRevocationClient 根据模块的 http_client_config 生成查询OCSP responder和CRL的client
+// 只使用代理和TLS相关的配置，认证信息不能发送给第三方；OCSP responder不是探测的target，不使用配置的 server_name
+func newRevocationClient(httpClientConfig pconfig.HTTPClientConfig) (*http.Client, error) {
+	cfg := pconfig.HTTPClientConfig{
+		ProxyURL:        httpClientConfig.ProxyURL,
+		TLSConfig:       httpClientConfig.TLSConfig,
+		FollowRedirects: true,
+		EnableHTTP2:     httpClientConfig.EnableHTTP2,
+	}
+	cfg.TLSConfig.ServerName = ""
+	return pconfig.NewClientFromConfig(cfg, "revocation", pconfig.WithKeepAlivesDisabled())
+}
+
+// fetch 下载OCSP响应或CRL，body 不为空时作为OCSP请求发送
+func fetch(ctx context.Context, client *http.Client, method, url string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/ocsp-request")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, url)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxRevocationResponseSize))
+}
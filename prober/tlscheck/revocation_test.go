@@ -0,0 +1,272 @@
+package tlscheck
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/utils"
+	"golang.org/x/crypto/ocsp"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// revocationPKI 测试用的ca、叶子证书，以及同时提供OCSP响应和CRL的服务器
+// 叶子证书中的地址无法解析，只能通过 proxy 访问，用于确认查询使用了模块的 http_client_config
+type revocationPKI struct {
+	ca, leaf *x509.Certificate
+	caKey    crypto.Signer
+	proxy    *url.URL
+	// ocspStatus 和 crlRevoked 控制服务器返回的吊销状态
+	ocspStatus int
+	crlRevoked bool
+}
+
+func newRevocationPKI(t *testing.T) *revocationPKI {
+	t.Helper()
+	pki := &revocationPKI{ocspStatus: ocsp.Good}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ocsp", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(pki.ocspResponse(t, pki.ocspStatus))
+	})
+	mux.HandleFunc("/crl", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(pki.crl(t))
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	proxy, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pki.proxy = proxy
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	pki.ca = createCertificate(t, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	pki.caKey = caKey
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pki.leaf = createCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "example.com"},
+		DNSNames:              []string{"example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		OCSPServer:            []string{"http://revocation.invalid/ocsp"},
+		CRLDistributionPoints: []string{"http://revocation.invalid/crl"},
+	}, pki.ca, &leafKey.PublicKey, caKey)
+	return pki
+}
+
+func createCertificate(t *testing.T, template, parent *x509.Certificate, pub any, key crypto.Signer) *x509.Certificate {
+	t.Helper()
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func (pki *revocationPKI) ocspResponse(t *testing.T, status int) []byte {
+	now := time.Now().Truncate(time.Second)
+	resp, err := ocsp.CreateResponse(pki.ca, pki.ca, ocsp.Response{
+		Status:       status,
+		SerialNumber: pki.leaf.SerialNumber,
+		ThisUpdate:   now.Add(-time.Minute),
+		NextUpdate:   now.Add(time.Hour),
+		RevokedAt:    now.Add(-time.Minute),
+	}, pki.caKey)
+	if err != nil {
+		t.Error(err)
+	}
+	return resp
+}
+
+func (pki *revocationPKI) crl(t *testing.T) []byte {
+	now := time.Now().Truncate(time.Second)
+	template := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: now.Add(-time.Minute),
+		NextUpdate: now.Add(time.Hour),
+	}
+	if pki.crlRevoked {
+		template.RevokedCertificates = []pkix.RevokedCertificate{{SerialNumber: pki.leaf.SerialNumber, RevocationTime: now.Add(-time.Minute)}}
+	}
+	crl, err := x509.CreateRevocationList(rand.Reader, template, pki.ca, pki.caKey)
+	if err != nil {
+		t.Error(err)
+	}
+	return crl
+}
+
+func checkRevocation(t *testing.T, r conf.RevocationConfig, proxy *url.URL, state *tls.ConnectionState) (bool, utils.FailureReason, map[string]float64) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ctx, failureReason := utils.NewFailureContext(ctx)
+	registry := prometheus.NewRegistry()
+	httpClientConfig := pconfig.DefaultHTTPClientConfig
+	httpClientConfig.ProxyURL = pconfig.URL{URL: proxy}
+	result := CheckRevocation(ctx, r, httpClientConfig, state, registry)
+
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := map[string]float64{}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			name := mf.GetName()
+			for _, label := range m.GetLabel() {
+				name += "{" + label.GetValue() + "}"
+			}
+			results[name] = m.GetGauge().GetValue()
+		}
+	}
+	return result, failureReason(), results
+}
+
+func TestCheckRevocationStapled(t *testing.T) {
+	pki := newRevocationPKI(t)
+
+	tests := []struct {
+		name           string
+		stapled        []byte
+		expectedResult bool
+		expectedStatus string
+	}{
+		{"not stapled", nil, true, ""},
+		{"good", pki.ocspResponse(t, ocsp.Good), true, "good"},
+		{"revoked", pki.ocspResponse(t, ocsp.Revoked), false, "revoked"},
+		{"unknown", pki.ocspResponse(t, ocsp.Unknown), true, "unknown"},
+		{"malformed", []byte("garbage"), true, ""},
+	}
+
+	for _, test := range tests {
+		state := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{pki.leaf, pki.ca}, OCSPResponse: test.stapled}
+		result, reason, results := checkRevocation(t, conf.RevocationConfig{CheckStapledOCSP: true}, nil, state)
+		if result != test.expectedResult {
+			t.Fatalf("%s: unexpected result %t", test.name, result)
+		}
+		if !result && reason != utils.FailureCertRevoked {
+			t.Errorf("%s: expected failure reason %q, got %q", test.name, utils.FailureCertRevoked, reason)
+		}
+		expectedStapled := 0.0
+		if test.stapled != nil {
+			expectedStapled = 1
+		}
+		if results["probe_ssl_ocsp_stapled"] != expectedStapled {
+			t.Errorf("%s: expected probe_ssl_ocsp_stapled %v, got %v", test.name, expectedStapled, results["probe_ssl_ocsp_stapled"])
+		}
+		if test.expectedStatus != "" && results["probe_ssl_revocation_status_info{stapled}{"+test.expectedStatus+"}"] != 1 {
+			t.Errorf("%s: expected stapled status %q, got %v", test.name, test.expectedStatus, results)
+		}
+		if test.expectedStatus == "" && results["probe_ssl_revocation_this_update_timestamp_seconds{stapled}"] != 0 {
+			t.Errorf("%s: unexpected stapled revocation metrics %v", test.name, results)
+		}
+	}
+}
+
+func TestCheckRevocationDisabled(t *testing.T) {
+	pki := newRevocationPKI(t)
+	// 没有开启 check_stapled_ocsp 时不检查stapled的响应，也不导出吊销相关的metrics
+	state := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{pki.leaf, pki.ca}, OCSPResponse: pki.ocspResponse(t, ocsp.Revoked)}
+	result, reason, results := checkRevocation(t, conf.RevocationConfig{}, nil, state)
+	if !result || reason != "" {
+		t.Fatalf("Expected revocation check to be skipped, got %t %q", result, reason)
+	}
+	if len(results) != 0 {
+		t.Errorf("Expected no metrics, got %v", results)
+	}
+}
+
+func TestCheckRevocationQuery(t *testing.T) {
+	pki := newRevocationPKI(t)
+	state := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{pki.leaf, pki.ca}}
+	r := conf.RevocationConfig{QueryOCSPResponder: true, QueryCRL: true}
+
+	result, reason, results := checkRevocation(t, r, pki.proxy, state)
+	if !result {
+		t.Fatalf("Revocation check failed unexpectedly: %s", reason)
+	}
+	for _, source := range []string{"ocsp", "crl"} {
+		if results["probe_ssl_revocation_status_info{"+source+"}{good}"] != 1 {
+			t.Errorf("Expected %s status good, got %v", source, results)
+		}
+		thisUpdate := results["probe_ssl_revocation_this_update_timestamp_seconds{"+source+"}"]
+		nextUpdate := results["probe_ssl_revocation_next_update_timestamp_seconds{"+source+"}"]
+		if thisUpdate <= 0 || nextUpdate <= thisUpdate {
+			t.Errorf("Unexpected %s update times %v - %v", source, thisUpdate, nextUpdate)
+		}
+	}
+
+	// 叶子证书中的地址只能通过模块配置的代理访问
+	result, reason, results = checkRevocation(t, r, nil, state)
+	if !result {
+		t.Fatalf("Expected query error to be ignored, got %q", reason)
+	}
+	if len(results) != 1 || results["probe_ssl_ocsp_stapled"] != 0 {
+		t.Errorf("Expected no revocation status without proxy, got %v", results)
+	}
+
+	pki.ocspStatus = ocsp.Revoked
+	result, reason, results = checkRevocation(t, conf.RevocationConfig{QueryOCSPResponder: true}, pki.proxy, state)
+	if result || reason != utils.FailureCertRevoked || results["probe_ssl_revocation_status_info{ocsp}{revoked}"] != 1 {
+		t.Fatalf("Expected OCSP revoked, got %t %q %v", result, reason, results)
+	}
+
+	pki.crlRevoked = true
+	result, reason, results = checkRevocation(t, conf.RevocationConfig{QueryCRL: true}, pki.proxy, state)
+	if result || reason != utils.FailureCertRevoked || results["probe_ssl_revocation_status_info{crl}{revoked}"] != 1 {
+		t.Fatalf("Expected CRL revoked, got %t %q %v", result, reason, results)
+	}
+
+	// 没有签发者证书时无法构造OCSP请求，只记录日志不影响结果
+	state = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{pki.leaf}}
+	if result, reason, _ := checkRevocation(t, conf.RevocationConfig{QueryOCSPResponder: true}, pki.proxy, state); !result {
+		t.Fatalf("Expected query error to be ignored, got %q", reason)
+	}
+	// 同样无法校验CRL的签名，不能使用未经校验的CRL
+	result, reason, results = checkRevocation(t, conf.RevocationConfig{QueryCRL: true}, pki.proxy, state)
+	if !result {
+		t.Fatalf("Expected query error to be ignored, got %q", reason)
+	}
+	for name := range results {
+		if strings.HasPrefix(name, "probe_ssl_revocation_status_info{crl}") {
+			t.Errorf("Unexpected CRL status without issuer: %v", results)
+		}
+	}
+}
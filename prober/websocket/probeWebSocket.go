@@ -0,0 +1,203 @@
+package websocket
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/utils"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"nhooyr.io/websocket"
+	"time"
+)
+
+// logger 返回本次探测使用的logger
+func logger(ctx context.Context) *zap.Logger {
+	return utils.LoggerFromContext(ctx, "WebSocket")
+}
+
+// handshakeTrace 记录握手过程中各阶段的时间点
+type handshakeTrace struct {
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	gotConn      time.Time
+}
+
+// ProbeWebSocket 通过 http_client_config 生成的http client完成websocket握手，并按照 query_response 依次收发消息
+func ProbeWebSocket(ctx context.Context, target string, module conf.Module, registry *prometheus.Registry) bool {
+	var (
+		durationGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_websocket_duration_seconds",
+			Help: "Duration of websocket request by phase, handshake is the time from getting a connection to receiving the upgrade response",
+		}, []string{"phase"})
+
+		statusCodeGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_websocket_status_code",
+			Help: "Response HTTP status code of the upgrade request",
+		})
+
+		closeCodeGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_websocket_close_code",
+			Help: "Status code of the close frame sent by the server while the probe was reading messages, 0 if the server did not close the connection",
+		})
+
+		probeFailedDueToRegex = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_failed_due_to_regex",
+			Help: "Indicates if probe failed due to regex",
+		})
+	)
+
+	for _, lv := range []string{"resolve", "connect", "tls", "handshake", "first_message"} {
+		durationGaugeVec.WithLabelValues(lv)
+	}
+	registry.MustRegister(durationGaugeVec, statusCodeGauge, closeCodeGauge, probeFailedDueToRegex)
+
+	l := logger(ctx)
+	wsConfig := *module.WebSocket
+
+	targetURL, err := url.Parse(target)
+	if err != nil || (targetURL.Scheme != "ws" && targetURL.Scheme != "wss") {
+		l.Error("Target must be a ws:// or wss:// URL", zap.String("target", target), zap.Error(err))
+		utils.RecordFailure(ctx, utils.FailureInvalidTarget)
+		return false
+	}
+	port := targetURL.Port()
+	if port == "" {
+		port = "80"
+		if targetURL.Scheme == "wss" {
+			port = "443"
+		}
+	}
+
+	// 握手只能使用HTTP/1.1
+	opts := []pconfig.HTTPClientOption{pconfig.WithKeepAlivesDisabled(), pconfig.WithHTTP2Disabled()}
+	// 配置了代理时由代理解析域名，否则连接解析出的ip，url中保留域名用于Host和SNI
+	if wsConfig.HTTPClientConfig.ProxyURL.URL == nil {
+		ip, lookupTime, err := conf.ChooseProtocol(ctx, wsConfig.IPProtocol, wsConfig.IPProtocolFallback, targetURL.Hostname(), registry)
+		if err != nil {
+			l.Error("Error resolving address", zap.Error(err))
+			return false
+		}
+		durationGaugeVec.WithLabelValues("resolve").Add(lookupTime)
+
+		dialer := &net.Dialer{}
+		opts = append(opts, pconfig.WithDialContextFunc(func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		}))
+	}
+
+	client, err := pconfig.NewClientFromConfig(wsConfig.HTTPClientConfig, "websocket_probe", opts...)
+	if err != nil {
+		l.Error("Error generating HTTP client", zap.Error(err))
+		utils.RecordFailure(ctx, utils.FailureConfig)
+		return false
+	}
+
+	header := http.Header{}
+	for key, value := range wsConfig.Headers {
+		header.Set(key, value)
+	}
+
+	ht := &handshakeTrace{}
+	trace := &httptrace.ClientTrace{
+		ConnectStart:      func(string, string) { ht.connectStart = time.Now() },
+		ConnectDone:       func(string, string, error) { ht.connectDone = time.Now() },
+		TLSHandshakeStart: func() { ht.tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { ht.tlsDone = time.Now() },
+		GotConn:           func(httptrace.GotConnInfo) { ht.gotConn = time.Now() },
+	}
+
+	l.Info("Making websocket handshake", zap.String("url", targetURL.String()))
+	conn, resp, err := websocket.Dial(httptrace.WithClientTrace(ctx, trace), targetURL.String(), &websocket.DialOptions{
+		HTTPClient: client,
+		HTTPHeader: header,
+	})
+	handshakeDone := time.Now()
+
+	if !ht.connectDone.IsZero() {
+		durationGaugeVec.WithLabelValues("connect").Add(ht.connectDone.Sub(ht.connectStart).Seconds())
+	}
+	if !ht.tlsDone.IsZero() {
+		durationGaugeVec.WithLabelValues("tls").Add(ht.tlsDone.Sub(ht.tlsStart).Seconds())
+	}
+	if !ht.gotConn.IsZero() {
+		durationGaugeVec.WithLabelValues("handshake").Add(handshakeDone.Sub(ht.gotConn).Seconds())
+	}
+
+	if resp != nil {
+		statusCodeGauge.Set(float64(resp.StatusCode))
+		if resp.TLS != nil {
+			utils.RegisterTLSStateMetrics(registry, resp.TLS)
+		}
+	}
+	if err != nil {
+		reason := utils.ClassifyError(err)
+		// 服务端没有同意升级
+		if resp != nil && resp.StatusCode != http.StatusSwitchingProtocols {
+			reason = utils.FailureStatusCode
+		}
+		l.Error("Error making websocket handshake", zap.String("reason", string(reason)), zap.Error(err))
+		utils.RecordFailure(ctx, reason)
+		return false
+	}
+	defer conn.Close(websocket.StatusInternalError, "probe failed")
+	l.Info("Websocket handshake succeeded", zap.String("subprotocol", conn.Subprotocol()))
+
+	firstMessage := true
+	for i, qr := range wsConfig.QueryResponse {
+		l.Info("Processing query response entry", zap.Int("entry_number", i))
+		if qr.Send != "" {
+			l.Debug("Sending message", zap.String("message", qr.Send))
+			if err := conn.Write(ctx, websocket.MessageText, []byte(qr.Send)); err != nil {
+				reason := utils.ClassifyError(err)
+				l.Error("Failed to send", zap.String("reason", string(reason)), zap.Error(err))
+				utils.RecordFailure(ctx, reason)
+				return false
+			}
+		}
+		if qr.Expect.Regexp == nil {
+			continue
+		}
+		// 逐条读取消息直到匹配
+		for {
+			_, message, err := conn.Read(ctx)
+			if err != nil {
+				// 服务端正常关闭连接仍未匹配，与tcp读到EOF一样视为正则失败
+				if code := websocket.CloseStatus(err); code != -1 {
+					closeCodeGauge.Set(float64(code))
+					l.Error("Connection closed before regexp matched", zap.String("regexp", qr.Expect.String()), zap.Int("close_code", int(code)))
+					probeFailedDueToRegex.Set(1)
+					utils.RecordFailure(ctx, utils.FailureRegexResponse)
+					return false
+				}
+				reason := utils.ClassifyError(err)
+				l.Error("Error reading message", zap.String("reason", string(reason)), zap.Error(err))
+				utils.RecordFailure(ctx, reason)
+				return false
+			}
+			if firstMessage {
+				durationGaugeVec.WithLabelValues("first_message").Add(time.Since(handshakeDone).Seconds())
+				firstMessage = false
+			}
+			l.Debug("Read message", zap.ByteString("message", message))
+			if qr.Expect.Match(message) {
+				l.Info("Regexp matched", zap.String("regexp", qr.Expect.String()))
+				break
+			}
+		}
+		probeFailedDueToRegex.Set(0)
+	}
+
+	// 主动关闭，服务端回复的close frame不影响 close_code
+	if err := conn.Close(websocket.StatusNormalClosure, ""); err != nil {
+		l.Warn("Error closing websocket connection", zap.Error(err))
+	}
+	return true
+}
@@ -0,0 +1,149 @@
+package websocket
+
+import (
+	pconfig "github.com/prometheus/common/config"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/prober/probetest"
+	"github.com/yuanyp8/http_exporter/utils"
+	"net/http"
+	"net/http/httptest"
+	"nhooyr.io/websocket"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestModule(wsProbe *conf.WebSocketProbe) conf.Module {
+	return conf.Module{
+		Prober:    "websocket",
+		Timeout:   time.Second,
+		WebSocket: wsProbe,
+	}
+}
+
+// echoHandler 握手后先发送welcome，之后原样返回收到的消息，收到 bye 时以 StatusGoingAway 关闭
+func echoHandler(w http.ResponseWriter, r *http.Request) {
+	if user, pass, ok := r.BasicAuth(); ok && (user != "user" || pass != "pass") {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close(websocket.StatusInternalError, "")
+
+	ctx := r.Context()
+	if err := conn.Write(ctx, websocket.MessageText, []byte("welcome")); err != nil {
+		return
+	}
+	for {
+		typ, message, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+		if string(message) == "bye" {
+			conn.Close(websocket.StatusGoingAway, "bye")
+			return
+		}
+		if err := conn.Write(ctx, typ, message); err != nil {
+			return
+		}
+	}
+}
+
+// wsURL 将httptest的地址转换为 ws:// 或 wss://
+func wsURL(ts *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+func TestWebSocketQueryResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(echoHandler))
+	defer ts.Close()
+
+	tests := []struct {
+		name              string
+		queryResponse     []conf.WebSocketQueryResponse
+		expectedResult    bool
+		expectedReason    utils.FailureReason
+		expectedCloseCode float64
+	}{
+		{"handshake only", nil, true, "", 0},
+		{"welcome", []conf.WebSocketQueryResponse{{Expect: *conf.MustNewRegexp("^welcome$")}}, true, "", 0},
+		{"echo", []conf.WebSocketQueryResponse{
+			{Send: "ping"},
+			{Send: "hello", Expect: *conf.MustNewRegexp("^hello$")},
+		}, true, "", 0},
+		// 服务端以 StatusGoingAway 关闭，probe 主动关闭时不记录 close_code
+		{"closed before match", []conf.WebSocketQueryResponse{
+			{Send: "bye", Expect: *conf.MustNewRegexp("^hello$")},
+		}, false, utils.FailureRegexResponse, 1001},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wsProbe := conf.NewDefaultWebSocketProbe()
+			wsProbe.QueryResponse = test.queryResponse
+
+			result, reason, registry := probetest.Probe(t, ProbeWebSocket, wsURL(ts), newTestModule(wsProbe))
+			probetest.CheckResult(t, result, reason, test.expectedResult, test.expectedReason)
+			probetest.CheckResults(t, registry, map[string]float64{
+				"probe_websocket_status_code": http.StatusSwitchingProtocols,
+				"probe_websocket_close_code":  test.expectedCloseCode,
+			})
+			results := probetest.Gather(t, registry)
+			if results["probe_websocket_duration_seconds{handshake}"] <= 0 {
+				t.Errorf("Handshake duration not recorded")
+			}
+			if len(test.queryResponse) > 0 && results["probe_websocket_duration_seconds{first_message}"] <= 0 {
+				t.Errorf("first_message duration not recorded")
+			}
+		})
+	}
+}
+
+func TestWebSocketHTTPClientConfig(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(echoHandler))
+	defer ts.Close()
+
+	tests := []struct {
+		name               string
+		insecureSkipVerify bool
+		basicAuth          *pconfig.BasicAuth
+		expectedResult     bool
+		expectedReason     utils.FailureReason
+		expectedStatusCode float64
+	}{
+		// 自签名证书校验失败
+		{"self-signed certificate", false, nil, false, utils.FailureCertInvalid, 0},
+		{"wrong password", true, &pconfig.BasicAuth{Username: "user", Password: "wrong"}, false, utils.FailureStatusCode, http.StatusUnauthorized},
+		{"basic_auth", true, &pconfig.BasicAuth{Username: "user", Password: "pass"}, true, "", http.StatusSwitchingProtocols},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wsProbe := conf.NewDefaultWebSocketProbe()
+			wsProbe.HTTPClientConfig.TLSConfig.InsecureSkipVerify = test.insecureSkipVerify
+			wsProbe.HTTPClientConfig.BasicAuth = test.basicAuth
+
+			result, reason, registry := probetest.Probe(t, ProbeWebSocket, wsURL(ts), newTestModule(wsProbe))
+			probetest.CheckResult(t, result, reason, test.expectedResult, test.expectedReason)
+			if !test.expectedResult && test.expectedStatusCode == 0 {
+				return
+			}
+			probetest.CheckResults(t, registry, map[string]float64{"probe_websocket_status_code": test.expectedStatusCode})
+			if !test.expectedResult {
+				return
+			}
+			probetest.CheckResults(t, registry, map[string]float64{"probe_tls_version_info{TLS 1.3}": 1})
+			if duration := probetest.Gather(t, registry)["probe_websocket_duration_seconds{tls}"]; duration <= 0 {
+				t.Errorf("TLS duration not recorded")
+			}
+		})
+	}
+}
+
+func TestWebSocketInvalidTarget(t *testing.T) {
+	result, reason, _ := probetest.Probe(t, ProbeWebSocket, "http://127.0.0.1/", newTestModule(conf.NewDefaultWebSocketProbe()))
+	probetest.CheckResult(t, result, reason, false, utils.FailureInvalidTarget)
+}
@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// FailureReason 探测失败的原因，作为 probe_failure_reason 的 reason label
+// 网络类的原因（dns/connect/tls/proxy/timeout）和应用类的原因（状态码、正则等）可以分别告警
+type FailureReason string
+
+const (
+	FailureInvalidTarget  FailureReason = "invalid_target"
+	FailureConfig         FailureReason = "config_error"
+	FailureDNSError       FailureReason = "dns_error"
+	FailureConnectRefused FailureReason = "connect_refused"
+	FailureConnectTimeout FailureReason = "connect_timeout"
+	FailureConnectError   FailureReason = "connect_error"
+	FailureProxyError     FailureReason = "proxy_error"
+	FailureSocket         FailureReason = "socket_error"
+	FailureTLSHandshake   FailureReason = "tls_handshake"
+	FailureCertInvalid    FailureReason = "cert_invalid"
+	FailureCertRevoked    FailureReason = "cert_revoked"
+	FailurePinMismatch    FailureReason = "cert_pin_mismatch"
+	FailureTLSPolicy      FailureReason = "tls_policy"
+	FailureTimeout        FailureReason = "timeout"
+	FailureStatusCode     FailureReason = "status_code"
+	FailureRedirect       FailureReason = "redirect"
+	FailureHTTPVersion    FailureReason = "http_version"
+	FailureRegexHeader    FailureReason = "regex_header"
+	FailureRegexBody      FailureReason = "regex_body"
+	FailureRegexURL       FailureReason = "regex_url"
+	FailureRegexResponse  FailureReason = "regex_response"
+	FailureRegexRR        FailureReason = "regex_rr"
+	FailureDNSRcode       FailureReason = "dns_rcode"
+	FailureGRPCStatus     FailureReason = "grpc_status"
+	FailureServingStatus  FailureReason = "serving_status"
+	FailureDecompression  FailureReason = "decompression_error"
+	FailureBodyTooLarge   FailureReason = "body_too_large"
+	FailureUnknown        FailureReason = "unknown"
+)
+
+type failureKey struct{}
+
+// failureRecorder 记录一次探测中第一个失败原因
+type failureRecorder struct {
+	mu     sync.Mutex
+	reason FailureReason
+}
+
+// NewFailureContext 返回可以记录失败原因的ctx，以及读取失败原因的函数
+// 没有记录任何原因时返回空字符串
+func NewFailureContext(ctx context.Context) (context.Context, func() FailureReason) {
+	r := &failureRecorder{}
+	return context.WithValue(ctx, failureKey{}, r), func() FailureReason {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return r.reason
+	}
+}
+
+// RecordFailure 记录探测失败的原因，只保留第一次记录的原因，后续的失败往往是它引起的
+// ctx 不是由 NewFailureContext 生成时什么也不做
+func RecordFailure(ctx context.Context, reason FailureReason) {
+	r, ok := ctx.Value(failureKey{}).(*failureRecorder)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.reason == "" {
+		r.reason = reason
+	}
+}
+
+// ClassifyError 根据网络请求返回的错误判断失败原因
+func ClassifyError(err error) FailureReason {
+	if err == nil {
+		return ""
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return FailureDNSError
+	}
+
+	var (
+		verificationErr     *tls.CertificateVerificationError
+		unknownAuthorityErr x509.UnknownAuthorityError
+		hostnameErr         x509.HostnameError
+		certInvalidErr      x509.CertificateInvalidError
+	)
+	if errors.As(err, &verificationErr) || errors.As(err, &unknownAuthorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &certInvalidErr) {
+		return FailureCertInvalid
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		switch opErr.Op {
+		case "proxyconnect", "socks connect":
+			return FailureProxyError
+		// crypto/tls 把收到和发出的alert包装为这两种操作
+		case "remote error", "local error":
+			return FailureTLSHandshake
+		case "dial":
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				return FailureConnectRefused
+			}
+			if opErr.Timeout() || errors.Is(err, context.DeadlineExceeded) {
+				return FailureConnectTimeout
+			}
+			return FailureConnectError
+		}
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return FailureConnectRefused
+	}
+
+	var (
+		recordHeaderErr tls.RecordHeaderError
+		alertErr        tls.AlertError
+	)
+	if errors.As(err, &recordHeaderErr) || errors.As(err, &alertErr) {
+		return FailureTLSHandshake
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return FailureTimeout
+	}
+	return FailureUnknown
+}
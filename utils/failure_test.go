@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestClassifyTLSError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected FailureReason
+	}{
+		{"unknown authority", &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}, FailureCertInvalid},
+		{"hostname mismatch", fmt.Errorf("get: %w", x509.HostnameError{Certificate: &x509.Certificate{}, Host: "example.com"}), FailureCertInvalid},
+		{"expired certificate", x509.CertificateInvalidError{Reason: x509.Expired}, FailureCertInvalid},
+		{"record header", tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, FailureTLSHandshake},
+		{"alert", tls.AlertError(40), FailureTLSHandshake},
+		{"remote alert", &net.OpError{Op: "remote error", Err: errors.New("tls: handshake failure")}, FailureTLSHandshake},
+		// 只是错误信息中包含 tls: 不代表是握手失败
+		{"tls in message", errors.New("read tls: config"), FailureUnknown},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if reason := ClassifyError(test.err); reason != test.expected {
+				t.Errorf("Expected reason %q, got %q", test.expected, reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package utils
+
+import "net/http"
+
+// RedactedHeaderValue 替换认证信息的占位符
+const RedactedHeaderValue = "<secret>"
+
+// sensitiveHeaders 输出header时需要隐藏的值
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// IsSensitiveHeader header是否包含认证信息，不区分大小写
+func IsSensitiveHeader(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	for _, sensitive := range sensitiveHeaders {
+		if name == sensitive {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactHeader 返回隐藏了认证信息的header
+func RedactHeader(header http.Header) http.Header {
+	redacted := header.Clone()
+	for name := range redacted {
+		if IsSensitiveHeader(name) {
+			redacted[name] = []string{RedactedHeaderValue}
+		}
+	}
+	return redacted
+}
@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"context"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"io"
 	"log"
 	"sync"
 )
@@ -25,3 +28,26 @@ func init() {
 	// 单次加载
 	once.Do(loadGlobalLogger)
 }
+
+type loggerKey struct{}
+
+// WithLogger 将单次探测专用的logger放入ctx
+func WithLogger(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// LoggerFromContext 返回ctx中名为name的logger，ctx中没有时使用全局的Logger
+func LoggerFromContext(ctx context.Context, name string) *zap.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
+		return logger.Named(name)
+	}
+	return Logger.Named(name)
+}
+
+// NewCaptureLogger 返回同时写入全局Logger和w的logger，w中会记录包括debug在内的所有日志
+func NewCaptureLogger(w io.Writer) *zap.Logger {
+	encoderConfig := zap.NewDevelopmentEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	capture := zapcore.NewCore(zapcore.NewConsoleEncoder(encoderConfig), zapcore.Lock(zapcore.AddSync(w)), zapcore.DebugLevel)
+	return zap.New(zapcore.NewTee(Logger.Core(), capture))
+}
@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"strings"
+	"time"
+)
+
+// GetEarliestCertExpiry 返回服务端证书链中最早过期的时间
+func GetEarliestCertExpiry(state *tls.ConnectionState) time.Time {
+	earliest := time.Time{}
+	for _, cert := range state.PeerCertificates {
+		if (earliest.IsZero() || cert.NotAfter.Before(earliest)) && !cert.NotAfter.IsZero() {
+			earliest = cert.NotAfter
+		}
+	}
+	return earliest
+}
+
+// GetLastChainExpiry 在所有通过校验的证书链中，取每条链最早过期时间的最大值
+// 即客户端还能信任该服务端的最晚时间
+func GetLastChainExpiry(state *tls.ConnectionState) time.Time {
+	lastChainExpiry := time.Time{}
+	for _, chain := range state.VerifiedChains {
+		earliestCertExpiry := time.Time{}
+		for _, cert := range chain {
+			if (earliestCertExpiry.IsZero() || cert.NotAfter.Before(earliestCertExpiry)) && !cert.NotAfter.IsZero() {
+				earliestCertExpiry = cert.NotAfter
+			}
+		}
+		if lastChainExpiry.IsZero() || lastChainExpiry.Before(earliestCertExpiry) {
+			lastChainExpiry = earliestCertExpiry
+		}
+	}
+	return lastChainExpiry
+}
+
+// GetFingerprint 返回叶子证书的sha256指纹
+func GetFingerprint(state *tls.ConnectionState) string {
+	if len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	fingerprint := sha256.Sum256(state.PeerCertificates[0].Raw)
+	return hex.EncodeToString(fingerprint[:])
+}
+
+// GetSPKIFingerprint 返回叶子证书公钥(SubjectPublicKeyInfo)的sha256指纹，证书续期但密钥不变时保持不变
+func GetSPKIFingerprint(state *tls.ConnectionState) string {
+	if len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	fingerprint := sha256.Sum256(state.PeerCertificates[0].RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(fingerprint[:])
+}
+
+// GetTLSVersion 返回协商的TLS版本，e.g. TLS 1.3
+func GetTLSVersion(state *tls.ConnectionState) string {
+	switch state.Version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return "unknown"
+	}
+}
+
+// RegisterTLSStateMetrics 注册并设置各prober共用的TLS连接metrics：证书过期时间、证书链信息以及TLS版本
+func RegisterTLSStateMetrics(registry prometheus.Registerer, state *tls.ConnectionState) {
+	var (
+		probeSSLEarliestCertExpiry = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_ssl_earliest_cert_expiry",
+			Help: "Returns earliest SSL cert expiry in unixtime",
+		})
+
+		probeSSLLastChainExpiryTimestampSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_ssl_last_chain_expiry_timestamp_seconds",
+			Help: "Returns last SSL chain expiry in timestamp seconds",
+		})
+
+		probeSSLLastInformation = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "probe_ssl_last_chain_info",
+				Help: "Contains SSL leaf certificate information",
+			},
+			[]string{"fingerprint_sha256", "spki_sha256"},
+		)
+
+		probeTLSVersion = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "probe_tls_version_info",
+				Help: "Contains the TLS version used",
+			},
+			[]string{"version"},
+		)
+	)
+
+	registry.MustRegister(probeSSLEarliestCertExpiry, probeTLSVersion, probeSSLLastChainExpiryTimestampSeconds, probeSSLLastInformation)
+	probeSSLEarliestCertExpiry.Set(float64(GetEarliestCertExpiry(state).Unix()))
+	probeTLSVersion.WithLabelValues(GetTLSVersion(state)).Set(1)
+	probeSSLLastChainExpiryTimestampSeconds.Set(float64(GetLastChainExpiry(state).Unix()))
+	probeSSLLastInformation.WithLabelValues(GetFingerprint(state), GetSPKIFingerprint(state)).Set(1)
+}
+
+// VerifyCertificates 使用roots校验服务端发送的证书链以及hostname，roots 为nil时使用系统根证书
+// 用于跳过了握手时的校验、但仍需要知道证书链是否可信的场景
+func VerifyCertificates(certs []*x509.Certificate, roots *x509.CertPool, hostname string) ([][]*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return nil, x509.CertificateInvalidError{Reason: x509.NotAuthorizedToSign, Detail: "no certificates presented"}
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       hostname,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	return certs[0].Verify(opts)
+}
+
+// GetKeyTypeAndSize 返回证书公钥的类型和位数，e.g. RSA 2048、ECDSA 256
+func GetKeyTypeAndSize(cert *x509.Certificate) (string, int) {
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", pub.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", pub.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", len(pub) * 8
+	default:
+		return cert.PublicKeyAlgorithm.String(), 0
+	}
+}
+
+// GetSubjectAlternativeNames 返回证书中所有的SAN，以逗号分隔
+func GetSubjectAlternativeNames(cert *x509.Certificate) string {
+	sans := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return strings.Join(sans, ",")
+}
+
+// VerifyErrorReason 将证书校验的错误归类，作为 probe_ssl_verify_error_info 的 reason label
+func VerifyErrorReason(err error) string {
+	var (
+		unknownAuthorityErr x509.UnknownAuthorityError
+		hostnameErr         x509.HostnameError
+		certInvalidErr      x509.CertificateInvalidError
+		systemRootsErr      x509.SystemRootsError
+	)
+	switch {
+	case errors.As(err, &unknownAuthorityErr):
+		return "unknown_authority"
+	case errors.As(err, &hostnameErr):
+		return "hostname_mismatch"
+	case errors.As(err, &certInvalidErr) && certInvalidErr.Reason == x509.Expired:
+		return "expired"
+	case errors.As(err, &certInvalidErr):
+		return "invalid"
+	case errors.As(err, &systemRootsErr):
+		return "system_roots"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,90 @@
+package web
+
+import (
+	"embed"
+	"fmt"
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/prober"
+	"github.com/yuanyp8/http_exporter/utils"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var l = utils.Logger.Named("Web")
+
+//go:embed templates
+var templates embed.FS
+
+var indexTemplate = template.Must(template.ParseFS(templates, "templates/index.html"))
+
+type moduleInfo struct {
+	Name    string
+	Prober  string
+	Timeout time.Duration
+}
+
+// IndexHandler 首页，展示已配置的模块和最近的探测结果
+func IndexHandler(sc *conf.SafeConfig, hist *prober.History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		sc.RLock()
+		c := sc.C
+		sc.RUnlock()
+
+		var modules []moduleInfo
+		for _, name := range c.ModuleNames() {
+			m := c.Modules[name]
+			modules = append(modules, moduleInfo{Name: name, Prober: m.Prober, Timeout: m.Timeout})
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := indexTemplate.Execute(w, struct {
+			Modules []moduleInfo
+			Results []*prober.Result
+		}{modules, hist.List()})
+		if err != nil {
+			l.Error("Error rendering index page", zap.Error(err))
+		}
+	}
+}
+
+// LogsHandler 返回某次探测的debug输出，e.g. /logs?id=1
+func LogsHandler(hist *prober.History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid probe id", http.StatusBadRequest)
+			return
+		}
+		result := hist.Get(id)
+		if result == nil {
+			http.Error(w, "Probe not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(result.DebugOutput()))
+	}
+}
+
+// ConfigHandler 返回当前生效的配置
+func ConfigHandler(sc *conf.SafeConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sc.RLock()
+		c, err := yaml.Marshal(sc.C)
+		sc.RUnlock()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error marshalling configuration: %s", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(c)
+	}
+}
@@ -0,0 +1,46 @@
+package web
+
+import (
+	"github.com/yuanyp8/http_exporter/conf"
+	"github.com/yuanyp8/http_exporter/prober"
+	"github.com/yuanyp8/http_exporter/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIndexHandler(t *testing.T) {
+	sc := &conf.SafeConfig{C: &conf.Config{Modules: map[string]conf.Module{
+		"http_2xx": {Prober: "http", HTTP: conf.NewDefaultHTTPProbe()},
+	}}}
+	hist := prober.NewHistory(10)
+	hist.Add(&prober.Result{Target: "<script>", Module: "http_2xx", FailureReason: utils.FailureTimeout, Logs: []byte("probe logs")})
+
+	rr := httptest.NewRecorder()
+	IndexHandler(sc, hist)(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	body := rr.Body.String()
+	for _, s := range []string{"<td>http_2xx</td>", "&lt;script&gt;", "timeout", `href="logs?id=0"`} {
+		if !strings.Contains(body, s) {
+			t.Errorf("Expected index page to contain %q, got:\n%s", s, body)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	IndexHandler(sc, hist)(rr, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown path, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	LogsHandler(hist)(rr, httptest.NewRequest(http.MethodGet, "/logs?id=0", nil))
+	if !strings.Contains(rr.Body.String(), "Logs for the probe:\nprobe logs") {
+		t.Errorf("Unexpected debug output %q", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	LogsHandler(hist)(rr, httptest.NewRequest(http.MethodGet, "/logs?id=1", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown probe, got %d", rr.Code)
+	}
+}